@@ -0,0 +1,463 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-go/ably"
+	"github.com/inconshreveable/log15"
+)
+
+// defaultCapability is the capability of tokens when no capability template
+// is configured.
+const defaultCapability = `{"*":["*"]}`
+
+// userNumberKey is the context key for the number of the user a client is
+// being initialised for.
+type userNumberKey struct{}
+
+// withUserNumber returns a context carrying the given user number.
+func withUserNumber(ctx context.Context, userNum int64) context.Context {
+	return context.WithValue(ctx, userNumberKey{}, userNum)
+}
+
+// UserNumberFromContext returns the number of the user a client is being
+// initialised for from the context passed to a NewClientFunc, or 0 if not set.
+func UserNumberFromContext(ctx context.Context) int64 {
+	userNum, _ := ctx.Value(userNumberKey{}).(int64)
+	return userNum
+}
+
+// authenticator generates the auth options of Ably clients based on
+// conf.Ably.AuthMode, rendering the clientId and capability of tokens using
+// the number of each user.
+type authenticator struct {
+	conf       *config.AblyConfig
+	clientID   *template.Template
+	capability *template.Template
+}
+
+// newAuthenticator parses the clientId and capability templates from the given
+// config, checking that the capability renders a JSON object.
+func newAuthenticator(conf *config.AblyConfig) (*authenticator, error) {
+	a := &authenticator{conf: conf}
+	if conf.ClientID != "" {
+		tmpl, err := template.New("clientId").Funcs(channelFuncs).Parse(conf.ClientID)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ably.client-id %q: %v", conf.ClientID, err)
+		}
+		a.clientID = tmpl
+	}
+	if conf.Capability != "" {
+		tmpl, err := template.New("capability").Funcs(channelFuncs).Parse(conf.Capability)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ably.capability %q: %v", conf.Capability, err)
+		}
+		a.capability = tmpl
+	}
+	if _, err := a.tokenParams(1); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// renderClientID renders the clientId of the given user, returning an empty
+// string if no clientId template is configured.
+func (a *authenticator) renderClientID(userNum int64) (string, error) {
+	if a.clientID == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := a.clientID.Execute(&buf, &ChannelTemplateData{UserNumber: userNum}); err != nil {
+		return "", fmt.Errorf("error rendering ably.client-id: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// tokenParams returns the params of tokens issued to the given user.
+func (a *authenticator) tokenParams(userNum int64) (*ably.TokenParams, error) {
+	clientID, err := a.renderClientID(userNum)
+	if err != nil {
+		return nil, err
+	}
+	params := &ably.TokenParams{
+		TTL:        a.conf.TokenTTL.Milliseconds(),
+		Capability: defaultCapability,
+		ClientID:   clientID,
+	}
+	data := &ChannelTemplateData{UserNumber: userNum}
+	if a.capability != nil {
+		var capability map[string]interface{}
+		if err := renderJSONObject(a.capability, data, &capability); err != nil {
+			return nil, fmt.Errorf("error rendering ably.capability: %v", err)
+		}
+		encoded, err := json.Marshal(capability)
+		if err != nil {
+			return nil, err
+		}
+		params.Capability = string(encoded)
+	}
+	return params, nil
+}
+
+// clientOptions returns the options to initialise the given user's client
+// with, which authenticate using the API key in the "key" auth mode, and
+// otherwise use token auth with tokens issued by an auth callback or URL.
+//
+// In the "key" auth mode, the client connects with the user's clientId if
+// configured, and if a capability is configured the client instead requests
+// tokens with the user's clientId and capability using the key, since the
+// capability of a connection can only be restricted using token auth.
+//
+// With token auth, the time taken to issue each token is recorded as
+// "tokenRequest" (by the auth callback, or by an authURLTransport when the
+// client library requests tokens from the auth URL), and the returned
+// tokenRenewals tracks renewals of the client's token so that the time taken
+// to reauthenticate can be measured. The renewals of a random
+// conf.TokenDenyFraction of users are denied with a 403 error, which should
+// cause their connections to fail.
+func (a *authenticator) clientOptions(userNum int64, recorder Recorder) ([]ably.ClientOption, *tokenRenewals, error) {
+	opts := a.conf.BaseClientOptions()
+	params, err := a.tokenParams(userNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.conf.AuthMode == "" || a.conf.AuthMode == config.AuthModeKey {
+		opts = append(opts, ably.WithKey(a.conf.APIKey))
+		if a.capability != nil {
+			return append(opts, ably.WithUseTokenAuth(true), ably.WithDefaultTokenParams(*params)), nil, nil
+		}
+		if params.ClientID != "" {
+			opts = append(opts, ably.WithClientID(params.ClientID))
+		}
+		return opts, nil, nil
+	}
+	opts = append(opts, ably.WithDefaultTokenParams(*params))
+
+	renewals := &tokenRenewals{}
+	deny := a.conf.TokenDenyFraction > 0 && rand.Float64() < a.conf.TokenDenyFraction
+	authCallback := func(issue func(context.Context) (ably.Tokener, error)) ably.ClientOption {
+		return ably.WithAuthCallback(func(ctx context.Context, _ ably.TokenParams) (ably.Tokener, error) {
+			if renewals.request() && deny {
+				recorder.RecordFailure("tokenRequest", 0, errTokenRenewalDenied)
+				return nil, &ably.ErrorInfo{StatusCode: http.StatusForbidden, Code: ably.ErrForbidden}
+			}
+			startTime := timeNow()
+			token, err := issue(ctx)
+			elapsedTime := timeNow() - startTime
+			if err != nil {
+				recorder.RecordFailure("tokenRequest", elapsedTime, err)
+				return nil, err
+			}
+			recorder.RecordSuccess("tokenRequest", elapsedTime, 0)
+			return token, nil
+		})
+	}
+	switch a.conf.AuthMode {
+	case config.AuthModeTokenRequest:
+		opts = append(opts, authCallback(func(context.Context) (ably.Tokener, error) {
+			return createTokenRequest(a.conf.APIKey, params)
+		}))
+	case config.AuthModeJWT:
+		opts = append(opts, authCallback(func(context.Context) (ably.Tokener, error) {
+			return createJWT(a.conf.APIKey, params, time.Now())
+		}))
+	case config.AuthModeAuthURL:
+		authURL, err := url.Parse(a.conf.AuthURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ably.auth-url: %v", err)
+		}
+		transport := &authURLTransport{
+			authURL:  authURL,
+			recorder: recorder,
+			renewals: renewals,
+			deny:     deny,
+			next:     http.DefaultTransport,
+		}
+		opts = append(opts,
+			ably.WithAuthURL(a.conf.AuthURL),
+			ably.WithAuthParams(userNumberQuery(userNum)),
+			ably.WithHTTPClient(&http.Client{Timeout: a.conf.RequestTimeout, Transport: transport}),
+		)
+	case config.AuthModeAuthCallback:
+		opts = append(opts, authCallback(func(ctx context.Context) (ably.Tokener, error) {
+			return requestAuthURL(ctx, a.conf.AuthURL, userNum, params)
+		}))
+	}
+	return opts, renewals, nil
+}
+
+// errTokenRenewalDenied is recorded when a user's token renewal is denied.
+var errTokenRenewalDenied = errors.New("token renewal denied")
+
+// authURLTransport is an http.RoundTripper for clients which request tokens
+// from an auth URL, which records requests to the auth URL as "tokenRequest"
+// and denies token renewals in the same way as the auth callback used by the
+// other token auth modes, passing all other requests to the next
+// RoundTripper.
+type authURLTransport struct {
+	authURL  *url.URL
+	recorder Recorder
+	renewals *tokenRenewals
+	deny     bool
+	next     http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *authURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != t.authURL.Scheme || req.URL.Host != t.authURL.Host || req.URL.Path != t.authURL.Path {
+		return t.next.RoundTrip(req)
+	}
+	if t.renewals.request() && t.deny {
+		t.recorder.RecordFailure("tokenRequest", 0, errTokenRenewalDenied)
+		return &http.Response{
+			Status:     http.StatusText(http.StatusForbidden),
+			StatusCode: http.StatusForbidden,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"error":{"code":40300,"statusCode":403,"message":"token renewal denied"}}`)),
+			Request:    req,
+		}, nil
+	}
+	startTime := timeNow()
+	res, err := t.next.RoundTrip(req)
+	elapsedTime := timeNow() - startTime
+	if err != nil {
+		t.recorder.RecordFailure("tokenRequest", elapsedTime, err)
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		t.recorder.RecordFailure("tokenRequest", elapsedTime, &ably.ErrorInfo{StatusCode: res.StatusCode, Code: ably.ErrErrorFromClientTokenCallback})
+	} else {
+		t.recorder.RecordSuccess("tokenRequest", elapsedTime, 0)
+	}
+	return res, nil
+}
+
+// tokenRenewals tracks the token requests of a client so that the time taken
+// to reauthenticate once its token needs renewing can be measured.
+type tokenRenewals struct {
+	mtx      sync.Mutex
+	requests int64
+	started  int64
+}
+
+// request counts a token request and returns whether it's a renewal (i.e.
+// not the client's first token request), starting the reauth timer if so.
+func (t *tokenRenewals) request() bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.requests++
+	if t.requests == 1 {
+		return false
+	}
+	if t.started == 0 {
+		t.started = timeNow()
+	}
+	return true
+}
+
+// done stops the reauth timer, returning the time since the first token
+// renewal request and true if a renewal was in progress.
+func (t *tokenRenewals) done() (int64, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.started == 0 {
+		return 0, false
+	}
+	elapsedTime := timeNow() - t.started
+	t.started = 0
+	return elapsedTime, true
+}
+
+// createTokenRequest returns a token request for the given params signed
+// locally using the given API key.
+func createTokenRequest(key string, params *ably.TokenParams) (*ably.TokenRequest, error) {
+	rest, err := ably.NewREST(ably.WithKey(key))
+	if err != nil {
+		return nil, err
+	}
+	p := *params
+	return rest.Auth.CreateTokenRequest(&p)
+}
+
+// createJWT returns an Ably JWT for the given params issued at the given time
+// and signed using the given API key.
+func createJWT(key string, params *ably.TokenParams, now time.Time) (ably.TokenString, error) {
+	i := strings.IndexByte(key, ':')
+	if i == -1 {
+		return "", errors.New("invalid API key, expected 'name:secret'")
+	}
+	keyName, keySecret := key[:i], key[i+1:]
+
+	header, err := json.Marshal(map[string]string{
+		"typ": "JWT",
+		"alg": "HS256",
+		"kid": keyName,
+	})
+	if err != nil {
+		return "", err
+	}
+	claims := map[string]interface{}{
+		"iat":               now.Unix(),
+		"exp":               now.Add(time.Duration(params.TTL) * time.Millisecond).Unix(),
+		"x-ably-capability": params.Capability,
+	}
+	if params.ClientID != "" {
+		claims["x-ably-clientId"] = params.ClientID
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(keySecret))
+	mac.Write([]byte(unsigned))
+	return ably.TokenString(unsigned + "." + encoding.EncodeToString(mac.Sum(nil))), nil
+}
+
+// userNumberQuery returns the query params sent to auth URLs which identify
+// the user requesting a token (see NewTokenServer).
+func userNumberQuery(userNum int64) url.Values {
+	return url.Values{"userNumber": {strconv.FormatInt(userNum, 10)}}
+}
+
+// requestAuthURL requests a token for the given user and params from the
+// given URL, which can respond with either a JSON token request or token
+// details, or a plain text token (e.g. a JWT).
+func requestAuthURL(ctx context.Context, authURL string, userNum int64, params *ably.TokenParams) (ably.Tokener, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	for k, v := range params.Query() {
+		query[k] = v
+	}
+	for k, v := range userNumberQuery(userNum) {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from auth URL: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	typ, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	switch typ {
+	case "application/json":
+		var tokenRequest ably.TokenRequest
+		if err := json.Unmarshal(body, &tokenRequest); err == nil && tokenRequest.MAC != "" {
+			return &tokenRequest, nil
+		}
+		var tokenDetails ably.TokenDetails
+		if err := json.Unmarshal(body, &tokenDetails); err != nil {
+			return nil, fmt.Errorf("error decoding auth URL response: %v", err)
+		}
+		return &tokenDetails, nil
+	case "text/plain":
+		return ably.TokenString(bytes.TrimSpace(body)), nil
+	default:
+		return nil, fmt.Errorf("unexpected auth URL response content type: %q", typ)
+	}
+}
+
+// NewTokenServer returns an http.Handler which issues tokens signed using
+// conf.Ably.APIKey so that token auth can be load tested without running a
+// separate auth server. It serves the following endpoints:
+//
+// /token-request: a JSON token request
+//
+// /jwt:           a JWT as plain text
+//
+// Since the tokens are signed with the API key, the clientId, capability and
+// TTL of each token are always those configured by conf.Ably.ClientID,
+// conf.Ably.Capability and conf.Ably.TokenTTL, rendered for the user number
+// in the userNumber query param (as sent by ably-boomer clients), rather than
+// those requested in the query params.
+func NewTokenServer(conf *config.Config, log log15.Logger) (http.Handler, error) {
+	auth, err := newAuthenticator(&conf.Ably)
+	if err != nil {
+		return nil, err
+	}
+	paramsFromQuery := func(r *http.Request) (*ably.TokenParams, error) {
+		var userNum int64
+		if v := r.URL.Query().Get("userNumber"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid userNumber %q", v)
+			}
+			userNum = n
+		}
+		return auth.tokenParams(userNum)
+	}
+	serveErr := func(w http.ResponseWriter, err error) {
+		log.Error("error issuing token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token-request", func(w http.ResponseWriter, r *http.Request) {
+		params, err := paramsFromQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("issuing token request", "clientId", params.ClientID, "capability", params.Capability, "ttl", params.TTL)
+		tokenRequest, err := createTokenRequest(conf.Ably.APIKey, params)
+		if err != nil {
+			serveErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokenRequest)
+	})
+	mux.HandleFunc("/jwt", func(w http.ResponseWriter, r *http.Request) {
+		params, err := paramsFromQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("issuing JWT", "clientId", params.ClientID, "capability", params.Capability, "ttl", params.TTL)
+		token, err := createJWT(conf.Ably.APIKey, params, time.Now())
+		if err != nil {
+			serveErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(token))
+	})
+	return mux, nil
+}
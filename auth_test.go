@@ -0,0 +1,285 @@
+package ablyboomer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-go/ably"
+	"github.com/inconshreveable/log15"
+)
+
+// testKey is a fake API key used to sign tokens in tests.
+const testKey = "app.key:secret"
+
+// TestAuthenticatorTokenParams tests rendering the clientId and capability
+// of each user's tokens.
+func TestAuthenticatorTokenParams(t *testing.T) {
+	auth, err := newAuthenticator(&config.AblyConfig{
+		TokenTTL:   time.Minute,
+		ClientID:   "user-{{ .UserNumber }}",
+		Capability: `{"room-{{ mod .UserNumber 2 }}": ["subscribe", "presence"]}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := auth.tokenParams(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.ClientID != "user-3" {
+		t.Fatalf("expected clientId user-3, got %q", params.ClientID)
+	}
+	if params.Capability != `{"room-1":["subscribe","presence"]}` {
+		t.Fatalf("unexpected capability: %q", params.Capability)
+	}
+	if params.TTL != 60000 {
+		t.Fatalf("expected TTL 60000, got %d", params.TTL)
+	}
+
+	// check the capability defaults to everything
+	auth, err = newAuthenticator(&config.AblyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params, _ := auth.tokenParams(1); params.ClientID != "" || params.Capability != defaultCapability {
+		t.Fatalf("unexpected default token params: %+v", params)
+	}
+
+	// check invalid templates are rejected
+	for _, conf := range []config.AblyConfig{
+		{ClientID: "{{ .Missing"},
+		{Capability: `["not", "an", "object"]`},
+	} {
+		if _, err := newAuthenticator(&conf); err == nil {
+			t.Fatalf("expected an error for %+v", conf)
+		}
+	}
+}
+
+// TestCreateJWT tests signing JWTs with the API key.
+func TestCreateJWT(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	token, err := createJWT(testKey, &ably.TokenParams{
+		TTL:        60000,
+		ClientID:   "user-1",
+		Capability: defaultCapability,
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 JWT parts, got %d", len(parts))
+	}
+
+	// check the signature
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil)); parts[2] != expected {
+		t.Fatalf("expected signature %q, got %q", expected, parts[2])
+	}
+
+	// check the header and claims
+	decode := func(part string, v interface{}) {
+		data, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var header map[string]string
+	decode(parts[0], &header)
+	if header["kid"] != "app.key" || header["alg"] != "HS256" {
+		t.Fatalf("unexpected JWT header: %v", header)
+	}
+	var claims map[string]interface{}
+	decode(parts[1], &claims)
+	if claims["iat"] != float64(1600000000) || claims["exp"] != float64(1600000060) {
+		t.Fatalf("unexpected JWT times: %v", claims)
+	}
+	if claims["x-ably-clientId"] != "user-1" || claims["x-ably-capability"] != defaultCapability {
+		t.Fatalf("unexpected JWT claims: %v", claims)
+	}
+}
+
+// TestTokenServer tests requesting tokens from the token server, checking
+// tokens are only issued with the configured clientId, capability and TTL.
+func TestTokenServer(t *testing.T) {
+	conf := config.Default()
+	conf.Ably.APIKey = testKey
+	conf.Ably.ClientID = "user-{{ .UserNumber }}"
+	conf.Ably.Capability = `{"room-{{ .UserNumber }}": ["subscribe"]}`
+	conf.Ably.TokenTTL = time.Minute
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	handler, err := NewTokenServer(conf, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// request a token with a wider capability and longer TTL than
+	// configured
+	params := &ably.TokenParams{ClientID: "admin", Capability: defaultCapability, TTL: 86400000}
+	tokener, err := requestAuthURL(context.Background(), srv.URL+"/token-request", 3, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenRequest, ok := tokener.(*ably.TokenRequest)
+	if !ok {
+		t.Fatalf("expected a token request, got %T", tokener)
+	}
+	if tokenRequest.KeyName != "app.key" || tokenRequest.MAC == "" {
+		t.Fatalf("expected a signed token request, got %+v", tokenRequest)
+	}
+	expected := ably.TokenParams{ClientID: "user-3", Capability: `{"room-3":["subscribe"]}`, TTL: 60000}
+	if got := tokenRequest.TokenParams; got.ClientID != expected.ClientID || got.Capability != expected.Capability || got.TTL != expected.TTL {
+		t.Fatalf("expected token request params %+v, got %+v", expected, got)
+	}
+
+	tokener, err = requestAuthURL(context.Background(), srv.URL+"/jwt", 3, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ok := tokener.(ably.TokenString)
+	if !ok || strings.Count(string(token), ".") != 2 {
+		t.Fatalf("expected a JWT, got %v", tokener)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.Split(string(token), ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["x-ably-clientId"] != expected.ClientID || claims["x-ably-capability"] != expected.Capability {
+		t.Fatalf("unexpected JWT claims: %v", claims)
+	}
+	if exp, iat := claims["exp"].(float64), claims["iat"].(float64); exp-iat != 60 {
+		t.Fatalf("expected a 60s JWT TTL, got %vs", exp-iat)
+	}
+
+	res, err := http.Get(srv.URL + "/jwt?userNumber=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid user number to return 400, got %d", res.StatusCode)
+	}
+	if _, err := requestAuthURL(context.Background(), srv.URL+"/missing", 3, params); err == nil {
+		t.Fatal("expected an error requesting a token from a missing endpoint")
+	}
+}
+
+// TestTokenRenewals tests recording token requests and denying renewals, both
+// when tokens are issued by an auth callback and when the client library
+// requests them from an auth URL.
+func TestTokenRenewals(t *testing.T) {
+	conf := config.Default()
+	conf.Ably.APIKey = testKey
+	conf.Ably.TokenTTL = time.Minute
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	handler, err := NewTokenServer(conf, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, mode := range []string{config.AuthModeJWT, config.AuthModeAuthURL} {
+		auth, err := newAuthenticator(&config.AblyConfig{
+			APIKey:            testKey,
+			AuthMode:          mode,
+			AuthURL:           srv.URL + "/jwt",
+			TokenTTL:          time.Minute,
+			TokenDenyFraction: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := NewSummaryRecorder()
+		opts, renewals, err := auth.clientOptions(1, recorder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rest, err := ably.NewREST(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// check the first token is issued
+		if _, err := rest.Auth.Authorize(context.Background(), nil); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if stats := taskStats(recorder, "tokenRequest"); stats.Count != 1 {
+			t.Fatalf("%s: expected 1 tokenRequest success, got %d", mode, stats.Count)
+		}
+		if _, ok := renewals.done(); ok {
+			t.Fatalf("%s: expected the first token request not to be a renewal", mode)
+		}
+
+		// check the renewal is denied
+		_, err = rest.Auth.Authorize(context.Background(), nil)
+		var errInfo *ably.ErrorInfo
+		if !errors.As(err, &errInfo) || errInfo.StatusCode != http.StatusForbidden {
+			t.Fatalf("%s: expected a 403 error, got %v", mode, err)
+		}
+		if stats := taskStats(recorder, "tokenRequest"); stats.Failures != 1 {
+			t.Fatalf("%s: expected 1 tokenRequest failure, got %d", mode, stats.Failures)
+		}
+		if _, ok := renewals.done(); !ok {
+			t.Fatalf("%s: expected a renewal to be in progress", mode)
+		}
+	}
+}
+
+// TestClientID tests rendering the clientId of each user.
+func TestClientID(t *testing.T) {
+	auth, err := newAuthenticator(&config.AblyConfig{
+		APIKey:   testKey,
+		AuthMode: config.AuthModeKey,
+		ClientID: "user-{{ .UserNumber }}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check clients using key auth connect with the clientId
+	opts, _, err := auth.clientOptions(3, NewSummaryRecorder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ably.NewREST(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientID := rest.Auth.ClientID(); clientID != "user-3" {
+		t.Fatalf("expected client to have clientId user-3, got %q", clientID)
+	}
+
+	// check users enter presence with the clientId, or user<N> by default
+	l := &loadTest{auth: auth}
+	if clientID := l.clientID(3); clientID != "user-3" {
+		t.Fatalf("expected clientID user-3, got %q", clientID)
+	}
+	l = &loadTest{}
+	if clientID := l.clientID(3); clientID != "user3" {
+		t.Fatalf("expected default clientID user3, got %q", clientID)
+	}
+}
@@ -2,8 +2,15 @@ package ablyboomer
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"regexp"
 	"strings"
+	"sync"
 	"text/template"
+
+	"github.com/ably/ably-go/ably"
 )
 
 // ChannelTemplateData is used to render templated lists of channels with the
@@ -41,3 +48,195 @@ func renderChannels(tmpl *template.Template, userNum int64) []string {
 	tmpl.Execute(&buf, &ChannelTemplateData{UserNumber: userNum})
 	return strings.Split(buf.String(), ",")
 }
+
+// filteredChannel returns the name of the given channel qualified with the
+// given subscription filter expression, or the channel name unchanged if the
+// filter is empty.
+func filteredChannel(channel, filter string) string {
+	if filter == "" {
+		return channel
+	}
+	return "[filter=" + base64.StdEncoding.EncodeToString([]byte(filter)) + "]" + channel
+}
+
+// isMetachannel returns whether the given channel is an Ably metachannel
+// (e.g. "[meta]log:push"), whose messages aren't published by the load test
+// so shouldn't be filtered by message name.
+func isMetachannel(channel string) bool {
+	return strings.HasPrefix(channel, "[meta]")
+}
+
+// splitList splits the given comma separated list, trimming whitespace and
+// ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// channelFamilyKey is the context key for the family of the channel being
+// subscribed to.
+type channelFamilyKey struct{}
+
+// withChannelFamily returns a context carrying the given channel family.
+func withChannelFamily(ctx context.Context, family string) context.Context {
+	return context.WithValue(ctx, channelFamilyKey{}, family)
+}
+
+// ChannelFamilyFromContext returns the family of the channel being subscribed
+// to from the context passed to Client.Subscribe, or an empty string if not
+// set.
+//
+// The family of a channel is the channel template it was rendered from with
+// each template action replaced with "*", so for example the channels
+// rendered from "sharded-{{ mod .UserNumber 5 }}" have the family "sharded-*".
+func ChannelFamilyFromContext(ctx context.Context) string {
+	family, _ := ctx.Value(channelFamilyKey{}).(string)
+	return family
+}
+
+// channelActionRe matches the actions in a channel template.
+var channelActionRe = regexp.MustCompile(`{{.*?}}`)
+
+// channelFamilies returns the family of each of the given channels which were
+// rendered from the given templated list of channels.
+//
+// If the list doesn't render one channel per template (for example if a
+// template renders multiple channels), then all channels have the family of
+// the whole list.
+func channelFamilies(list string, channels []string) []string {
+	families := strings.Split(channelActionRe.ReplaceAllString(list, "*"), ",")
+	for i := range families {
+		families[i] = strings.TrimSpace(families[i])
+	}
+	if len(families) != len(channels) {
+		family := strings.Join(families, ",")
+		families = make([]string, len(channels))
+		for i := range families {
+			families[i] = family
+		}
+	}
+	return families
+}
+
+// channelStatName returns the stat name for the given channel family, which
+// is the given name suffixed with ":<family>", or just the given name if the
+// family is empty.
+func channelStatName(name, family string) string {
+	if family == "" {
+		return name
+	}
+	return name + ":" + family
+}
+
+// errChannelContinuityLost is recorded when a channel is re-attached without
+// resuming, meaning messages may have been lost.
+var errChannelContinuityLost = errors.New("channel continuity lost")
+
+// channelWatcher records stats about the state of an Ably channel from its
+// state changes, using stat names suffixed with the channel's family (see
+// channelStatName):
+//
+// channel<State>: a failure for each transition to DETACHED, SUSPENDED or
+//                 FAILED (e.g. "channelSuspended:sharded-*"), with the reason
+//                 grouped by Ably error code (see stateReasonError)
+//
+// reattach:       the time from the channel leaving the ATTACHED state until
+//                 it is ATTACHED again (or 0 if it is re-attached in place
+//                 when the connection resumes), recorded as a failure if
+//                 message continuity was lost
+//
+// It also tracks when the channel was last ready to publish messages (i.e.
+// not ATTACHING) so that the time publishes spend queued can be measured (see
+// ablyClient.Publish).
+type channelWatcher struct {
+	recorder Recorder
+	family   string
+
+	mtx        sync.Mutex
+	attached   bool
+	detachedAt int64
+	readyAt    int64
+}
+
+// newChannelWatcher returns a channelWatcher for a channel of the given
+// family which is currently in the given state.
+func newChannelWatcher(recorder Recorder, family string, state ably.ChannelState) *channelWatcher {
+	c := &channelWatcher{
+		recorder: recorder,
+		family:   family,
+		attached: state == ably.ChannelStateAttached,
+	}
+	if state != ably.ChannelStateAttaching {
+		c.readyAt = timeNow()
+	}
+	return c
+}
+
+// lastReady returns the time the channel was last ready to publish messages,
+// or 0 if it is ATTACHING.
+func (c *channelWatcher) lastReady() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.readyAt
+}
+
+// handle records stats for the given channel state change.
+func (c *channelWatcher) handle(change ably.ChannelStateChange) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if change.Current == ably.ChannelStateAttaching {
+		c.readyAt = 0
+	} else if c.readyAt == 0 {
+		c.readyAt = timeNow()
+	}
+
+	switch change.Event {
+	case ably.ChannelEventAttached:
+		if !c.attached {
+			// the initial attach is recorded by the client
+			c.attached = true
+			return
+		}
+		c.recordReattach(change)
+	case ably.ChannelEventUpdate:
+		if c.attached {
+			c.recordReattach(change)
+		}
+	case ably.ChannelEventAttaching:
+		if c.attached && c.detachedAt == 0 {
+			c.detachedAt = timeNow()
+		}
+	case ably.ChannelEventDetached, ably.ChannelEventSuspended, ably.ChannelEventFailed:
+		if c.attached && c.detachedAt == 0 {
+			c.detachedAt = timeNow()
+		}
+		name := "channel" + strings.Title(strings.ToLower(change.Current.String()))
+		c.recorder.RecordFailure(channelStatName(name, c.family), 0, &stateReasonError{
+			subject: "channel",
+			state:   change.Current.String(),
+			reason:  change.Reason,
+		})
+	}
+}
+
+// recordReattach records the channel being re-attached, and must be called
+// with the mutex held.
+func (c *channelWatcher) recordReattach(change ably.ChannelStateChange) {
+	var latency int64
+	if c.detachedAt > 0 {
+		latency = timeNow() - c.detachedAt
+		c.detachedAt = 0
+	}
+	name := channelStatName("reattach", c.family)
+	if change.Resumed {
+		c.recorder.RecordSuccess(name, latency, 0)
+	} else {
+		c.recorder.RecordFailure(name, latency, errChannelContinuityLost)
+	}
+}
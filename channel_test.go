@@ -0,0 +1,119 @@
+package ablyboomer
+
+import (
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/ably/ably-go/ably"
+)
+
+// TestFilteredChannel tests qualifying channel names with subscription
+// filter expressions.
+func TestFilteredChannel(t *testing.T) {
+	if name := filteredChannel("test", ""); name != "test" {
+		t.Fatalf("expected unfiltered channel name to be unchanged, got %q", name)
+	}
+	expected := "[filter=bmFtZSA9PSBgImV2ZW50LTEiYA==]test"
+	if name := filteredChannel("test", "name == `\"event-1\"`"); name != expected {
+		t.Fatalf("expected filtered channel name %q, got %q", expected, name)
+	}
+}
+
+// TestSplitList tests splitting comma separated lists.
+func TestSplitList(t *testing.T) {
+	if items := splitList(" a, b ,,c,"); !reflect.DeepEqual(items, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected items: %q", items)
+	}
+	if items := splitList(""); items != nil {
+		t.Fatalf("expected no items, got %q", items)
+	}
+}
+
+// TestChannelFamilies tests determining the family of rendered channels.
+func TestChannelFamilies(t *testing.T) {
+	list := `personal-{{ printf "%04d" .UserNumber }}, sharded-{{ mod .UserNumber 5 }}`
+	tmpl, err := template.New("channel").Funcs(channelFuncs).Parse(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels := renderChannels(tmpl, 7)
+	if families := channelFamilies(list, channels); !reflect.DeepEqual(families, []string{"personal-*", "sharded-*"}) {
+		t.Fatalf("unexpected families: %q", families)
+	}
+
+	// check channels have the family of the whole list if they don't
+	// correspond to the templates
+	list = `{{ range $i := .Shards }}shard-{{ $i }},{{ end }}`
+	expected := []string{"*shard-*,*", "*shard-*,*", "*shard-*,*"}
+	if families := channelFamilies(list, []string{"shard-1", "shard-2", "shard-3"}); !reflect.DeepEqual(families, expected) {
+		t.Fatalf("unexpected families: %q", families)
+	}
+
+	if name := channelStatName("attach", "sharded-*"); name != "attach:sharded-*" {
+		t.Fatalf("unexpected stat name: %q", name)
+	}
+	if name := channelStatName("attach", ""); name != "attach" {
+		t.Fatalf("unexpected stat name: %q", name)
+	}
+}
+
+// TestChannelWatcher tests recording channel state changes and re-attaches.
+func TestChannelWatcher(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	watcher := newChannelWatcher(recorder, "sharded-*", ably.ChannelStateInitialized)
+	for _, change := range []ably.ChannelStateChange{
+		{Event: ably.ChannelEventAttaching, Current: ably.ChannelStateAttaching},
+		{Event: ably.ChannelEventAttached, Current: ably.ChannelStateAttached},
+		{Event: ably.ChannelEventUpdate, Current: ably.ChannelStateAttached, Resumed: true},
+		{Event: ably.ChannelEventSuspended, Current: ably.ChannelStateSuspended, Reason: &ably.ErrorInfo{Code: 91200, StatusCode: 500}},
+		{Event: ably.ChannelEventAttaching, Current: ably.ChannelStateAttaching},
+		{Event: ably.ChannelEventAttached, Current: ably.ChannelStateAttached},
+		{Event: ably.ChannelEventDetached, Current: ably.ChannelStateDetached},
+		{Event: ably.ChannelEventFailed, Current: ably.ChannelStateFailed, Reason: &ably.ErrorInfo{Code: 40160, StatusCode: 401}},
+	} {
+		watcher.handle(change)
+	}
+
+	// check the resumed update succeeded and the re-attach after being
+	// suspended lost continuity
+	stats := taskStats(recorder, "reattach:sharded-*")
+	if stats.Count != 1 || stats.FailuresByMessage[errChannelContinuityLost.Error()] != 1 {
+		t.Fatalf("expected 1 reattach success and 1 failure, got %+v", stats)
+	}
+
+	// check state failures are recorded with grouped reasons
+	for name, msg := range map[string]string{
+		"channelSuspended:sharded-*": "channel suspended: error code 91200 (status code 500)",
+		"channelDetached:sharded-*":  "channel detached",
+		"channelFailed:sharded-*":    "channel failed: error code 40160 (status code 401)",
+	} {
+		if stats := taskStats(recorder, name); stats.Failures != 1 || stats.FailuresByMessage[msg] != 1 {
+			t.Fatalf("expected 1 %s %q failure, got %v", name, msg, stats.FailuresByMessage)
+		}
+	}
+}
+
+// TestChannelWatcherAttached tests that a channel which is already attached
+// when it starts being watched records re-attaches.
+func TestChannelWatcherAttached(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	watcher := newChannelWatcher(recorder, "personal-*", ably.ChannelStateAttached)
+	watcher.handle(ably.ChannelStateChange{Event: ably.ChannelEventUpdate, Current: ably.ChannelStateAttached, Resumed: true})
+	if stats := taskStats(recorder, "reattach:personal-*"); stats.Count != 1 {
+		t.Fatalf("expected 1 reattach success, got %+v", stats)
+	}
+}
+
+// TestChannelWatcherReady tests tracking when a channel is ready to publish
+// messages.
+func TestChannelWatcherReady(t *testing.T) {
+	watcher := newChannelWatcher(NewSummaryRecorder(), "", ably.ChannelStateAttaching)
+	if readyAt := watcher.lastReady(); readyAt != 0 {
+		t.Fatalf("expected an ATTACHING channel not to be ready, got %d", readyAt)
+	}
+	watcher.handle(ably.ChannelStateChange{Event: ably.ChannelEventAttached, Current: ably.ChannelStateAttached})
+	if readyAt := watcher.lastReady(); readyAt == 0 {
+		t.Fatal("expected an ATTACHED channel to be ready")
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/ably/ably-boomer/config"
 	"github.com/ably/ably-go/ably"
 	"github.com/inconshreveable/log15"
-	"github.com/myzhan/boomer"
 	"github.com/r3labs/sse"
 )
 
@@ -21,9 +20,15 @@ import (
 type Client interface {
 	// Subscribe subscribes to the given channel and calls the given
 	// handler for each message received.
+	//
+	// The family of the channel can be retrieved from the given context
+	// using ChannelFamilyFromContext.
 	Subscribe(ctx context.Context, channel string, handler func(msg *ably.Message)) error
 
 	// Publish publishes the given message on the given channel.
+	//
+	// The family of the channel can be retrieved from the given context
+	// using ChannelFamilyFromContext.
 	Publish(ctx context.Context, channel string, messages []*ably.Message) error
 
 	// Enter enters the given channel using the given clientID.
@@ -36,7 +41,12 @@ type Client interface {
 // NewClientFunc is the type of function that initialises a client, and is
 // typically NewAblyClient but may also be a custom function if ablyboomer
 // is used as a library to test using different types of clients.
-type NewClientFunc func(context.Context, *config.Config, log15.Logger) (Client, error)
+//
+// The given Recorder should be used to record any stats the client
+// generates itself (e.g. reconnection latency), and the number of the user
+// the client is for can be retrieved from the given context using
+// UserNumberFromContext.
+type NewClientFunc func(context.Context, *config.Config, Recorder, log15.Logger) (Client, error)
 
 // newClientFuncs is the list of registered NewClientFuncs
 var newClientFuncs = make(map[string]NewClientFunc)
@@ -62,56 +72,67 @@ func init() {
 	RegisterNewClientFunc("ably-sse", NewAblySSEClient)
 }
 
-// NewAblyClient is a NewClientFunc that initialises an Ably realtime client.
+// NewAblyClient is a NewClientFunc that initialises an Ably realtime client,
+// authenticating as configured by conf.Ably.AuthMode (see authenticator).
 //
-// A goroutine is started to watch and report connection events, and the client
-// is only returned once a CONNECTED event is received.
-func NewAblyClient(ctx context.Context, conf *config.Config, log log15.Logger) (Client, error) {
-	client, err := ably.NewRealtime(conf.Ably.ClientOptions()...)
+// The client's connection events are watched and reported by a
+// connectionWatcher, and the client is only returned once a CONNECTED event
+// is received.
+func NewAblyClient(ctx context.Context, conf *config.Config, recorder Recorder, log log15.Logger) (Client, error) {
+	auth, err := newAuthenticator(&conf.Ably)
+	if err != nil {
+		return nil, err
+	}
+	opts, renewals, err := auth.clientOptions(UserNumberFromContext(ctx), recorder)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ably.NewRealtime(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	// watch connection events
-	firstErr := make(chan error)
+	// watch connection events, registering the handler before connecting
+	// so that no events are missed
+	watcher := newConnectionWatcher(recorder, renewals)
+	unsub := client.Connection.OnAll(func(state ably.ConnectionStateChange) {
+		log.Debug("got ably connection state change", "event", state.Event, "reason", state.Reason)
+		if conf.Prometheus.Enabled {
+			observeConnectionStateChange(state)
+		}
+		watcher.handle(state)
+	})
 	go func() {
-		var once sync.Once
-		var disconnectedAt int64
-		done := make(chan struct{})
-		unsub := client.Connection.OnAll(func(state ably.ConnectionStateChange) {
-			log.Debug("got ably connection state change", "event", state.Event, "reason", state.Reason)
-			switch state.Event {
-			case ably.ConnectionEventConnected:
-				once.Do(func() { firstErr <- nil })
-				if disconnectedAt > 0 {
-					reconnectLatency := timeNow() - disconnectedAt
-					boomer.RecordSuccess("ablyboomer", "reconnect", reconnectLatency, 0)
-				}
-			case ably.ConnectionEventDisconnected:
-				disconnectedAt = timeNow()
-			case ably.ConnectionEventFailed:
-				once.Do(func() { firstErr <- state.Reason })
-			case ably.ConnectionEventClosed:
-				close(done)
-			}
-		})
-		defer unsub()
-		<-done
+		<-watcher.done
+		unsub()
 	}()
 
 	// connect and wait for the first CONNECTED or FAILED event
 	client.Connect()
 	select {
-	case err := <-firstErr:
-		return newAblyClient(client, conf, log), err
+	case err := <-watcher.firstErr:
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return newAblyClient(client, watcher, conf, recorder, log)
 	case <-ctx.Done():
+		client.Close()
+		watcher.stop()
 		return nil, ctx.Err()
 	}
 }
 
-// newAblyClient returns a new Ably client.
-func newAblyClient(realtime *ably.Realtime, conf *config.Config, log log15.Logger) *ablyClient {
-	client := &ablyClient{realtime, nil}
+// newAblyClient returns a new Ably client which uses channel options based on
+// the configured channel modes and cipher key.
+func newAblyClient(realtime *ably.Realtime, watcher *connectionWatcher, conf *config.Config, recorder Recorder, log log15.Logger) (*ablyClient, error) {
+	client := &ablyClient{
+		Realtime:       realtime,
+		watcher:        watcher,
+		recorder:       recorder,
+		subscribeNames: splitList(conf.Subscriber.MessageNames),
+		rewind:         conf.Subscriber.Rewind,
+	}
 
 	var modes []ably.ChannelMode
 	for _, channelMode := range strings.Split(conf.Ably.ChannelModes, ",") {
@@ -133,54 +154,270 @@ func newAblyClient(realtime *ably.Realtime, conf *config.Config, log log15.Logge
 		client.channelOptions = append(client.channelOptions, ably.ChannelWithModes(modes...))
 	}
 
-	return client
+	key, err := conf.Ably.DecodeCipherKey()
+	if err != nil {
+		realtime.Close()
+		return nil, fmt.Errorf("invalid ably.cipher-key: %v", err)
+	}
+	if key != nil {
+		client.channelOptions = append(client.channelOptions, ably.ChannelWithCipherKey(key))
+	}
+
+	return client, nil
 }
 
 // ablyClient implements the Client interface using a wrapped ably.Realtime
 // client.
 type ablyClient struct {
 	*ably.Realtime
+	watcher        *connectionWatcher
 	channelOptions []ably.ChannelOption
+	recorder       Recorder
+
+	// subscribeNames are the message names to subscribe to, or nil to
+	// subscribe to all messages
+	subscribeNames []string
+
+	// rewind is the rewind channel param, or empty to not rewind
+	rewind string
+
+	// channelWatchers are the watchers of each channel the client has
+	// attached, so that each channel is only watched once
+	channelWatchersMtx sync.Mutex
+	channelWatchers    map[string]*channelWatcher
+}
+
+// errRewindAttached is recorded as a "rewind" failure when subscribing to a
+// channel which is already attached, since the rewound messages are delivered
+// when the channel attaches so the subscription doesn't receive them.
+var errRewindAttached = errors.New("channel already attached, rewound messages not received")
+
+// channel returns the Ably channel with the given name, with the channel
+// options and rewind param (unless it's a metachannel) set when the channel
+// is first obtained by any method, since they are ignored for channels which
+// have already been obtained.
+func (a *ablyClient) channel(channelName string) *ably.RealtimeChannel {
+	opts := a.channelOptions
+	if a.rewind != "" && !isMetachannel(channelName) {
+		opts = append(opts[:len(opts):len(opts)], ably.ChannelWithParams("rewind", a.rewind))
+	}
+	return a.Realtime.Channels.Get(channelName, opts...)
 }
 
 // Subscribe subscribes to the given Ably channel and calls the given handler
-// with the data of each message received.
+// with the data of each message received, only subscribing to messages with
+// the names in conf.Subscriber.MessageNames if set (unless the channel is a
+// metachannel).
+//
+// The channel is explicitly attached first (see ablyClient.attach), and if
+// conf.Subscriber.Rewind is set but the channel is already attached (e.g. by
+// entering presence), a "rewind" failure is recorded since the rewound
+// messages have already been delivered.
 func (a *ablyClient) Subscribe(ctx context.Context, channelName string, handler func(*ably.Message)) error {
-	channel := a.Realtime.Channels.Get(channelName, a.channelOptions...)
-	unsub, err := channel.SubscribeAll(ctx, func(msg *ably.Message) {
-		handler(msg)
-	})
-	if err != nil {
+	channel := a.channel(channelName)
+	if a.rewind != "" && !isMetachannel(channelName) {
+		if state := channel.State(); state == ably.ChannelStateAttaching || state == ably.ChannelStateAttached {
+			a.recorder.RecordFailure("rewind", 0, errRewindAttached)
+		}
+	}
+	if err := a.attach(ctx, channel); err != nil {
 		return err
 	}
-	defer unsub()
+	names := a.subscribeNames
+	if isMetachannel(channelName) {
+		names = nil
+	}
+	if len(names) == 0 {
+		unsub, err := channel.SubscribeAll(ctx, func(msg *ably.Message) {
+			handler(msg)
+		})
+		if err != nil {
+			return err
+		}
+		defer unsub()
+	}
+	for _, name := range names {
+		unsub, err := channel.Subscribe(ctx, name, func(msg *ably.Message) {
+			handler(msg)
+		})
+		if err != nil {
+			return err
+		}
+		defer unsub()
+	}
 	<-ctx.Done()
 	return ctx.Err()
 }
 
+// attach watches the given channel (see ablyClient.watchChannel) and attaches
+// it if it isn't already attached, recording the time taken as "attach"
+// (suffixed with the channel's family from ChannelFamilyFromContext, see
+// channelStatName).
+func (a *ablyClient) attach(ctx context.Context, channel *ably.RealtimeChannel) error {
+	family := ChannelFamilyFromContext(ctx)
+	a.watchChannel(channel, family)
+	if channel.State() == ably.ChannelStateAttached {
+		return nil
+	}
+	startTime := timeNow()
+	err := channel.Attach(ctx)
+	elapsedTime := timeNow() - startTime
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	} else if err != nil {
+		a.recorder.RecordFailure(channelStatName("attach", family), elapsedTime, err)
+		return err
+	}
+	a.recorder.RecordSuccess(channelStatName("attach", family), elapsedTime, 0)
+	return nil
+}
+
+// watchChannel watches the state of the given channel with a channelWatcher
+// for the given family, unless the channel is already being watched so that
+// each state change is only recorded once per client, and returns the
+// channel's watcher.
+func (a *ablyClient) watchChannel(channel *ably.RealtimeChannel, family string) *channelWatcher {
+	a.channelWatchersMtx.Lock()
+	defer a.channelWatchersMtx.Unlock()
+	if a.channelWatchers == nil {
+		a.channelWatchers = make(map[string]*channelWatcher)
+	}
+	if watcher, ok := a.channelWatchers[channel.Name]; ok {
+		return watcher
+	}
+	watcher := newChannelWatcher(a.recorder, family, channel.State())
+	channel.OnAll(watcher.handle)
+	a.channelWatchers[channel.Name] = watcher
+	return watcher
+}
+
 // Publish publishes the given message to the given Ably channel.
+//
+// Messages are queued whilst the connection isn't CONNECTED, and Ably only
+// processes messages published whilst the channel is ATTACHING once it is
+// attached, so the time from calling Publish until both the connection and
+// the channel are ready is recorded as "publishQueued" (which is 0 if they
+// are ready when it is called), separately from the time until the message
+// is acknowledged which the caller records.
+//
+// The channel is watched (see ablyClient.watchChannel) using the family from
+// ChannelFamilyFromContext.
 func (a *ablyClient) Publish(ctx context.Context, channelName string, messages []*ably.Message) error {
-	return a.Realtime.Channels.Get(channelName, a.channelOptions...).PublishMultiple(ctx, messages)
+	channel := a.channel(channelName)
+	watcher := a.watchChannel(channel, ChannelFamilyFromContext(ctx))
+	ready := func() int64 {
+		connectedAt, readyAt := a.watcher.lastConnected(), watcher.lastReady()
+		if connectedAt == 0 || readyAt == 0 {
+			return 0
+		} else if readyAt < connectedAt {
+			return connectedAt
+		}
+		return readyAt
+	}
+
+	startTime := timeNow()
+	if ready() > 0 {
+		a.recorder.RecordSuccess("publishQueued", 0, 0)
+		return channel.PublishMultiple(ctx, messages)
+	}
+	err := channel.PublishMultiple(ctx, messages)
+	elapsedTime := timeNow() - startTime
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	readyAt := ready()
+	switch {
+	case readyAt > 0:
+		queuedTime := readyAt - startTime
+		if queuedTime < 0 {
+			queuedTime = 0
+		} else if queuedTime > elapsedTime {
+			queuedTime = elapsedTime
+		}
+		a.recorder.RecordSuccess("publishQueued", queuedTime, 0)
+	case err != nil:
+		// the message was never sent
+		a.recorder.RecordFailure("publishQueued", elapsedTime, err)
+	default:
+		// the message was sent, but the connection or channel is no
+		// longer ready so when isn't known
+		a.recorder.RecordSuccess("publishQueued", elapsedTime, 0)
+	}
+	return err
+}
+
+// History returns a request for the history of the given Ably channel.
+func (a *ablyClient) History(channelName string, opts ...ably.HistoryOption) ably.HistoryRequest {
+	return a.channel(channelName).History(opts...)
 }
 
 // Enter enters the given Ably channel using the given clientID.
 func (a *ablyClient) Enter(ctx context.Context, channelName, clientID string) error {
-	return a.Realtime.Channels.Get(channelName, a.channelOptions...).Presence.EnterClient(ctx, clientID, "")
+	return a.channel(channelName).Presence.EnterClient(ctx, clientID, "")
+}
+
+// EnterWithData enters the given Ably channel using the given clientID and
+// data.
+func (a *ablyClient) EnterWithData(ctx context.Context, channelName, clientID string, data interface{}) error {
+	return a.channel(channelName).Presence.EnterClient(ctx, clientID, data)
+}
+
+// UpdateWithData updates the presence data of the given clientID in the given
+// Ably channel.
+func (a *ablyClient) UpdateWithData(ctx context.Context, channelName, clientID string, data interface{}) error {
+	return a.channel(channelName).Presence.UpdateClient(ctx, clientID, data)
+}
+
+// LeaveWithData leaves the given Ably channel using the given clientID and
+// data.
+func (a *ablyClient) LeaveWithData(ctx context.Context, channelName, clientID string, data interface{}) error {
+	return a.channel(channelName).Presence.LeaveClient(ctx, clientID, data)
+}
+
+// SubscribePresence subscribes to presence events on the given Ably channel
+// and calls the given handler with each presence message received.
+//
+// The channel is explicitly attached first (see ablyClient.attach).
+func (a *ablyClient) SubscribePresence(ctx context.Context, channelName string, handler func(*ably.PresenceMessage)) error {
+	channel := a.channel(channelName)
+	if err := a.attach(ctx, channel); err != nil {
+		return err
+	}
+	unsub, err := channel.Presence.SubscribeAll(ctx, handler)
+	if err != nil {
+		return err
+	}
+	defer unsub()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// GetPresence gets the current presence members of the given Ably channel.
+func (a *ablyClient) GetPresence(ctx context.Context, channelName string) ([]*ably.PresenceMessage, error) {
+	return a.channel(channelName).Presence.Get(ctx)
 }
 
 // Close closes the underlying ably.Realtime client.
 func (a *ablyClient) Close() error {
 	a.Realtime.Close()
+	a.watcher.stop()
 	return nil
 }
 
 // NewAblySSEClient is a NewClientFunc that initialises a client that
 // subscribes to Ably channels using Server-Sent-Events (SSE).
-func NewAblySSEClient(ctx context.Context, conf *config.Config, log log15.Logger) (Client, error) {
-	return &ablySSEClient{
+func NewAblySSEClient(ctx context.Context, conf *config.Config, recorder Recorder, log log15.Logger) (Client, error) {
+	client := &ablySSEClient{
 		conf: conf,
 		stop: make(chan struct{}),
-	}, nil
+	}
+	if names := splitList(conf.Subscriber.MessageNames); len(names) > 0 {
+		client.subscribeNames = make(map[string]bool, len(names))
+		for _, name := range names {
+			client.subscribeNames[name] = true
+		}
+	}
+	return client, nil
 }
 
 // ablySSEClient implements the Subscribe method of the Client interface using
@@ -189,36 +426,61 @@ type ablySSEClient struct {
 	conf     *config.Config
 	stopOnce sync.Once
 	stop     chan struct{}
+
+	// subscribeNames are the message names to subscribe to, or nil to
+	// subscribe to all messages
+	subscribeNames map[string]bool
 }
 
 // Subscribe subscribes to the given Ably channel using SSE and calls the given
-// handler with the data of each non-empty message received.
+// handler with the data of each non-empty message received, only calling it
+// for messages with the names in conf.Subscriber.MessageNames if set (since
+// SSE doesn't support subscribing by name, other messages are still
+// received).
 func (a *ablySSEClient) Subscribe(ctx context.Context, channelName string, handler func(*ably.Message)) error {
-	u := url.URL{
-		Scheme:   "https",
-		Host:     "realtime.ably.io",
-		Path:     "/sse",
-		RawQuery: "channels=" + channelName + "&v=1.1&key=" + a.conf.Ably.APIKey,
-	}
-	if env := a.conf.Ably.Environment; env != "" && env != "production" {
-		u.Host = env + "-" + u.Host
-	}
-	client := sse.NewClient(u.String())
+	client := sse.NewClient(a.url(channelName))
 	client.Connection.Timeout = a.conf.Ably.RequestTimeout
 	ctx, cancel := context.WithCancel(ctx)
 	go func() {
 		<-a.stop
 		cancel()
 	}()
+	filterNames := a.subscribeNames != nil && !isMetachannel(channelName)
 	return client.SubscribeWithContext(ctx, "", func(event *sse.Event) {
 		if len(event.Data) > 0 {
 			var msg ably.Message
 			json.Unmarshal(event.Data, &msg)
+			if filterNames && !a.subscribeNames[msg.Name] {
+				return
+			}
 			handler(&msg)
 		}
 	})
 }
 
+// url returns the SSE URL for subscribing to the given Ably channel, which
+// may include a channel qualifier (e.g. a subscription filter).
+func (a *ablySSEClient) url(channelName string) string {
+	query := url.Values{
+		"channels": {channelName},
+		"v":        {"1.1"},
+		"key":      {a.conf.Ably.APIKey},
+	}
+	if rewind := a.conf.Subscriber.Rewind; rewind != "" {
+		query.Set("rewind", rewind)
+	}
+	u := url.URL{
+		Scheme:   "https",
+		Host:     "realtime.ably.io",
+		Path:     "/sse",
+		RawQuery: query.Encode(),
+	}
+	if env := a.conf.Ably.Environment; env != "" && env != "production" {
+		u.Host = env + "-" + u.Host
+	}
+	return u.String()
+}
+
 // Publish is not compatible with SSE.
 func (a *ablySSEClient) Publish(ctx context.Context, channelName string, messages []*ably.Message) error {
 	return errors.New("Publish not implemented for SSE client")
@@ -0,0 +1,32 @@
+package ablyboomer
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// TestSSEClientURL tests the SSE URL escapes qualified channel names.
+func TestSSEClientURL(t *testing.T) {
+	conf := config.Default()
+	conf.Ably.APIKey = "app.key:secret"
+	conf.Subscriber.Rewind = "10"
+	client := &ablySSEClient{conf: conf}
+
+	channel := filteredChannel("test", "name == `\"event-1\"`")
+	u, err := url.Parse(client.url(channel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	for key, expected := range map[string]string{
+		"channels": channel,
+		"key":      conf.Ably.APIKey,
+		"rewind":   "10",
+	} {
+		if value := query.Get(key); value != expected {
+			t.Fatalf("expected %s to be %q, got %q", key, expected, value)
+		}
+	}
+}
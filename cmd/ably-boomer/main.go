@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -42,10 +45,47 @@ func main() {
 				log.Info("received signal, exiting...", "signal", sig)
 			}()
 
-			// run the worker until it exits
+			// run the worker until it exits, exiting non-zero if
+			// any thresholds were breached
 			worker.Run(ctx)
+			if breaches := worker.ThresholdBreaches(); len(breaches) > 0 {
+				return fmt.Errorf("%d threshold(s) breached: %v", len(breaches), breaches)
+			}
 			return nil
 		},
+		Commands: []*cli.Command{
+			{
+				Name:  "token-server",
+				Usage: "Run an HTTP server which issues tokens signed using the API key for testing token auth",
+				Action: func(c *cli.Context) error {
+					handler, err := ablyboomer.NewTokenServer(conf, log)
+					if err != nil {
+						return err
+					}
+					srv := &http.Server{
+						Addr:    conf.TokenServer.Addr,
+						Handler: handler,
+					}
+
+					// shutdown gracefully on SIGINT or SIGTERM
+					go func() {
+						ch := make(chan os.Signal, 1)
+						signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+						sig := <-ch
+						log.Info("received signal, exiting...", "signal", sig)
+						ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+						defer cancel()
+						srv.Shutdown(ctx)
+					}()
+
+					log.Info("starting token server", "addr", srv.Addr)
+					if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+						return err
+					}
+					return nil
+				},
+			},
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Crit("error running ably-boomer", "err", err)
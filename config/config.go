@@ -1,6 +1,10 @@
 package config
 
 import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ably/ably-boomer/perf"
@@ -20,6 +24,7 @@ func Default() *Config {
 
 	conf.Subscriber.Enabled = false
 	conf.Subscriber.Channels = "ably-boomer-test"
+	conf.Subscriber.LossGracePeriod = 10 * time.Second
 	conf.Subscriber.PushDevice = SubscriberPushDeviceConfig{
 		Enabled:            false,
 		URL:                "https://rest.ably.io",
@@ -29,12 +34,32 @@ func Default() *Config {
 	conf.Publisher.Enabled = false
 	conf.Publisher.Channels = "ably-boomer-test"
 	conf.Publisher.PublishInterval = time.Second
+	conf.Publisher.RateMode = "constant"
+	conf.Publisher.BurstSize = 10
+	conf.Publisher.BatchSize = 1
 	conf.Publisher.MessageSize = 2 * units.KiB
+	conf.Publisher.PayloadType = "hex"
+	conf.Publisher.SizeDistribution = "fixed"
 	conf.Publisher.PushEnabled = false
 
+	conf.Presence.ChurnAbsence = time.Second
+
+	conf.PresenceSubscriber.Enabled = false
+	conf.PresenceSubscriber.Channels = "ably-boomer-test"
+	conf.PresenceSubscriber.CheckInterval = 30 * time.Second
+
+	conf.History.Enabled = false
+	conf.History.Channels = "ably-boomer-test"
+	conf.History.Client = "rest"
+	conf.History.Interval = 10 * time.Second
+	conf.History.Limit = 100
+	conf.History.Direction = "backwards"
+	conf.History.MaxPages = 1
+
 	conf.Standalone.Enabled = false
 	conf.Standalone.Users = 1
 	conf.Standalone.SpawnRate = 1
+	conf.Standalone.SummaryFormat = "json"
 
 	conf.Locust.Host = "127.0.0.1"
 	conf.Locust.Port = 5557
@@ -42,6 +67,13 @@ func Default() *Config {
 	conf.Ably.ConnectionTimeout = 4 * time.Second
 	conf.Ably.RequestTimeout = 10 * time.Second
 	conf.Ably.ChannelModes = "" // Use default modes.
+	conf.Ably.Protocol = ProtocolMsgPack
+	conf.Ably.AuthMode = AuthModeKey
+	conf.Ably.TokenTTL = time.Hour
+
+	conf.TokenServer.Addr = "localhost:8091"
+
+	conf.Perf.KeyPrefix = "perf"
 
 	conf.Log.Level = log15.LvlInfo.String()
 
@@ -50,22 +82,38 @@ func Default() *Config {
 	conf.Redis.ConnectTimeout = 5 * time.Second
 	conf.Redis.WorkerNumberKey = "ably-boomer:worker-number"
 
+	conf.Results.Format = "csv"
+	conf.Results.Interval = 5 * time.Second
+
+	conf.Thresholds.CheckInterval = 5 * time.Second
+	conf.Thresholds.AbortOnBreach = false
+
+	conf.HTTP.Enabled = false
+	conf.HTTP.Addr = ":8090"
+
 	return conf
 }
 
 type Config struct {
-	Client       string
-	UserLifetime time.Duration
-	Subscriber   SubscriberConfig
-	Publisher    PublisherConfig
-	Presence     PresenceConfig
-	Standalone   StandaloneConfig
-	Locust       LocustConfig
-	Ably         AblyConfig
-	Perf         perf.Conf
-	Log          LogConfig
-	Redis        RedisConf
-	Custom       interface{}
+	Client             string
+	UserLifetime       time.Duration
+	Subscriber         SubscriberConfig
+	Publisher          PublisherConfig
+	Presence           PresenceConfig
+	PresenceSubscriber PresenceSubscriberConfig
+	History            HistoryConfig
+	Standalone         StandaloneConfig
+	Locust             LocustConfig
+	Ably               AblyConfig
+	Perf               perf.Conf
+	Log                LogConfig
+	Redis              RedisConf
+	HTTP               HTTPConfig
+	Thresholds         ThresholdsConfig
+	Results            ResultsConfig
+	TokenServer        TokenServerConfig
+	Prometheus         PrometheusConfig
+	Custom             interface{}
 }
 
 const (
@@ -74,9 +122,18 @@ const (
 	ClientCustom  = "custom"
 )
 
+const (
+	ProtocolJSON    = "json"
+	ProtocolMsgPack = "msgpack"
+)
+
 type SubscriberConfig struct {
 	Enabled           bool
 	Channels          string
+	LossGracePeriod   time.Duration
+	MessageNames      string
+	Filter            string
+	Rewind            string
 	PushDevice        SubscriberPushDeviceConfig
 }
 
@@ -92,19 +149,84 @@ type PublisherConfig struct {
 	Enabled         bool
 	Channels        string
 	PublishInterval time.Duration
+	RateMode        string
+	BurstSize       int
+	RampEndInterval time.Duration
+	RampDuration    time.Duration
+	Steps           string
+	StartJitter     time.Duration
+	BatchSize       int
+	BatchLinger     time.Duration
 	MessageSize     int64
-	PushEnabled     bool
+	PayloadType     string
+	PayloadTemplate string
+	PayloadDir      string
+
+	SizeDistribution  string
+	MessageSizeMin    int64
+	MessageSizeMax    int64
+	MessageSizeStdDev int64
+	MessageSizes      string
+
+	MessageName    string
+	MessageExtras  string
+	MessageHeaders string
+
+	PushEnabled bool
 }
 
 type PresenceConfig struct {
-	Enabled  bool
-	Channels string
+	Enabled        bool
+	Channels       string
+	Data           string
+	UpdateInterval time.Duration
+	ChurnInterval  time.Duration
+	ChurnAbsence   time.Duration
 }
 
-type StandaloneConfig struct {
+type PresenceSubscriberConfig struct {
+	Enabled       bool
+	Channels      string
+	CheckInterval time.Duration
+	Users         int64
+}
+
+// ValidateRewind checks that the rewind is either a number of messages or a
+// duration.
+func (s *SubscriberConfig) ValidateRewind() error {
+	if s.Rewind == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(s.Rewind); err == nil {
+		if n < 1 {
+			return fmt.Errorf("invalid subscriber.rewind: %q", s.Rewind)
+		}
+		return nil
+	}
+	if d, err := time.ParseDuration(s.Rewind); err != nil || d <= 0 {
+		return fmt.Errorf("invalid subscriber.rewind: %q, expected a number of messages or a duration", s.Rewind)
+	}
+	return nil
+}
+
+type HistoryConfig struct {
 	Enabled   bool
-	Users     int
-	SpawnRate float64
+	Channels  string
+	Client    string
+	Interval  time.Duration
+	Limit     int
+	Direction string
+	TimeRange time.Duration
+	MaxPages  int
+}
+
+type StandaloneConfig struct {
+	Enabled       bool
+	Users         int
+	SpawnRate     float64
+	Duration      time.Duration
+	SummaryFile   string
+	SummaryFormat string
 }
 
 type LocustConfig struct {
@@ -118,11 +240,87 @@ type AblyConfig struct {
 	ConnectionTimeout time.Duration
 	RequestTimeout    time.Duration
 	ChannelModes      string
+	Protocol          string
+	CipherKey         string
+	AuthMode          string
+	AuthURL           string
+	TokenTTL          time.Duration
+	ClientID          string
+	Capability        string
+	TokenDenyFraction float64
 }
 
+const (
+	AuthModeKey          = "key"
+	AuthModeTokenRequest = "token-request"
+	AuthModeJWT          = "jwt"
+	AuthModeAuthURL      = "auth-url"
+	AuthModeAuthCallback = "auth-callback"
+)
+
+// Validate checks that the protocol, cipher key and auth mode are valid.
+func (a *AblyConfig) Validate() error {
+	switch a.Protocol {
+	case "", ProtocolJSON, ProtocolMsgPack:
+	default:
+		return fmt.Errorf("invalid ably.protocol: %q", a.Protocol)
+	}
+	switch a.AuthMode {
+	case "", AuthModeKey:
+	case AuthModeTokenRequest, AuthModeJWT:
+		if !strings.Contains(a.APIKey, ":") {
+			return fmt.Errorf("ably.auth-mode %q requires ably.api-key to be set to a key in the form 'name:secret'", a.AuthMode)
+		}
+	case AuthModeAuthURL, AuthModeAuthCallback:
+		if a.AuthURL == "" {
+			return fmt.Errorf("ably.auth-mode %q requires ably.auth-url to be set", a.AuthMode)
+		}
+	default:
+		return fmt.Errorf("invalid ably.auth-mode: %q", a.AuthMode)
+	}
+	if a.TokenDenyFraction < 0 || a.TokenDenyFraction > 1 {
+		return fmt.Errorf("invalid ably.token-deny-fraction: %v, expected a value between 0 and 1", a.TokenDenyFraction)
+	}
+	if a.TokenDenyFraction > 0 {
+		switch a.AuthMode {
+		case AuthModeTokenRequest, AuthModeJWT, AuthModeAuthCallback:
+		default:
+			return fmt.Errorf("ably.token-deny-fraction requires ably.auth-mode to be one of %q, %q or %q", AuthModeTokenRequest, AuthModeJWT, AuthModeAuthCallback)
+		}
+	}
+	if _, err := a.DecodeCipherKey(); err != nil {
+		return fmt.Errorf("invalid ably.cipher-key: %v", err)
+	}
+	return nil
+}
+
+// DecodeCipherKey decodes the base64 encoded cipher key used to encrypt
+// channels, which must be either 128 or 256 bits to use AES-128 or AES-256
+// respectively, returning nil if no key is configured.
+func (a *AblyConfig) DecodeCipherKey() ([]byte, error) {
+	if a.CipherKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(a.CipherKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("expected a 128 or 256 bit key, got %d bits", len(key)*8)
+	}
+	return key, nil
+}
+
+// ClientOptions returns the options for clients which authenticate using the
+// API key.
 func (a *AblyConfig) ClientOptions() []ably.ClientOption {
+	return append([]ably.ClientOption{ably.WithKey(a.APIKey)}, a.BaseClientOptions()...)
+}
+
+// BaseClientOptions returns the options for clients other than those used
+// to authenticate.
+func (a *AblyConfig) BaseClientOptions() []ably.ClientOption {
 	opts := []ably.ClientOption{
-		ably.WithKey(a.APIKey),
 		// Set the connection and request timeouts for REST.
 		ably.WithHTTPRequestTimeout(a.RequestTimeout),
 		// Set the connection timeout for Realtime.
@@ -133,9 +331,19 @@ func (a *AblyConfig) ClientOptions() []ably.ClientOption {
 	if a.Environment != "" {
 		opts = append(opts, ably.WithEnvironment(a.Environment))
 	}
+	switch a.Protocol {
+	case ProtocolJSON:
+		opts = append(opts, ably.WithUseBinaryProtocol(false))
+	case ProtocolMsgPack:
+		opts = append(opts, ably.WithUseBinaryProtocol(true))
+	}
 	return opts
 }
 
+type TokenServerConfig struct {
+	Addr string
+}
+
 type LogConfig struct {
 	Level string
 }
@@ -146,3 +354,24 @@ type RedisConf struct {
 	ConnectTimeout  time.Duration
 	WorkerNumberKey string
 }
+
+type HTTPConfig struct {
+	Enabled bool
+	Addr    string
+}
+
+type PrometheusConfig struct {
+	Enabled bool
+}
+
+type ThresholdsConfig struct {
+	Rules         string
+	CheckInterval time.Duration
+	AbortOnBreach bool
+}
+
+type ResultsConfig struct {
+	File     string
+	Format   string
+	Interval time.Duration
+}
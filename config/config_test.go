@@ -0,0 +1,81 @@
+package config
+
+import "testing"
+
+// TestAblyConfigValidate tests validating the Ably protocol and cipher key.
+func TestAblyConfigValidate(t *testing.T) {
+	for _, test := range []struct {
+		protocol  string
+		cipherKey string
+		keyLength int
+		valid     bool
+	}{
+		{protocol: ProtocolMsgPack, valid: true},
+		{protocol: ProtocolJSON, valid: true},
+		{protocol: "xml", valid: false},
+		{protocol: ProtocolJSON, cipherKey: "MDEyMzQ1Njc4OWFiY2RlZg==", keyLength: 128, valid: true},
+		{protocol: ProtocolJSON, cipherKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", keyLength: 256, valid: true},
+		{protocol: ProtocolJSON, cipherKey: "MDEyMzQ1Njc=", valid: false},
+		{protocol: ProtocolJSON, cipherKey: "not base64!", valid: false},
+	} {
+		conf := AblyConfig{Protocol: test.protocol, CipherKey: test.cipherKey}
+		err := conf.Validate()
+		if test.valid && err != nil {
+			t.Fatalf("expected protocol %q and cipher key %q to be valid, got %v", test.protocol, test.cipherKey, err)
+		} else if !test.valid && err == nil {
+			t.Fatalf("expected protocol %q and cipher key %q to be invalid", test.protocol, test.cipherKey)
+		}
+		if test.valid {
+			key, _ := conf.DecodeCipherKey()
+			if len(key)*8 != test.keyLength {
+				t.Fatalf("expected a %d bit key, got %d bits", test.keyLength, len(key)*8)
+			}
+		}
+	}
+}
+
+// TestSubscriberConfigValidateRewind tests validating the subscriber rewind.
+func TestSubscriberConfigValidateRewind(t *testing.T) {
+	for rewind, valid := range map[string]bool{
+		"":     true,
+		"10":   true,
+		"30s":  true,
+		"2m":   true,
+		"0":    false,
+		"-1s":  false,
+		"last": false,
+	} {
+		conf := SubscriberConfig{Rewind: rewind}
+		if err := conf.ValidateRewind(); valid && err != nil {
+			t.Fatalf("expected rewind %q to be valid, got %v", rewind, err)
+		} else if !valid && err == nil {
+			t.Fatalf("expected rewind %q to be invalid", rewind)
+		}
+	}
+}
+
+// TestAblyConfigValidateAuthMode tests validating the Ably auth mode.
+func TestAblyConfigValidateAuthMode(t *testing.T) {
+	for _, test := range []struct {
+		conf  AblyConfig
+		valid bool
+	}{
+		{conf: AblyConfig{AuthMode: AuthModeKey}, valid: true},
+		{conf: AblyConfig{AuthMode: AuthModeTokenRequest, APIKey: "app.key:secret"}, valid: true},
+		{conf: AblyConfig{AuthMode: AuthModeTokenRequest}, valid: false},
+		{conf: AblyConfig{AuthMode: AuthModeJWT, APIKey: "app.key:secret"}, valid: true},
+		{conf: AblyConfig{AuthMode: AuthModeJWT, APIKey: "app.key"}, valid: false},
+		{conf: AblyConfig{AuthMode: AuthModeAuthURL, AuthURL: "http://localhost:8091/jwt"}, valid: true},
+		{conf: AblyConfig{AuthMode: AuthModeAuthCallback}, valid: false},
+		{conf: AblyConfig{AuthMode: "oauth"}, valid: false},
+		{conf: AblyConfig{AuthMode: AuthModeJWT, APIKey: "app.key:secret", TokenDenyFraction: 0.1}, valid: true},
+		{conf: AblyConfig{AuthMode: AuthModeJWT, APIKey: "app.key:secret", TokenDenyFraction: 1.5}, valid: false},
+		{conf: AblyConfig{AuthMode: AuthModeAuthURL, AuthURL: "http://localhost:8091/jwt", TokenDenyFraction: 0.1}, valid: false},
+	} {
+		if err := test.conf.Validate(); test.valid && err != nil {
+			t.Fatalf("expected auth mode %q to be valid, got %v", test.conf.AuthMode, err)
+		} else if !test.valid && err == nil {
+			t.Fatalf("expected auth mode %q to be invalid with %+v", test.conf.AuthMode, test.conf)
+		}
+	}
+}
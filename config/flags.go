@@ -48,6 +48,34 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Subscriber.Channels,
 			EnvVars:     []string{"SUBSCRIBER_CHANNELS"},
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "subscriber.loss-grace-period",
+			Usage:       "How long a message can be missing before it is reported as lost (0 to disable loss, duplicate and out of order detection)",
+			Value:       c.Subscriber.LossGracePeriod,
+			Destination: &c.Subscriber.LossGracePeriod,
+			EnvVars:     []string{"SUBSCRIBER_LOSS_GRACE_PERIOD"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "subscriber.message-names",
+			Usage:       "Only subscribe to messages with these names (comma separated)",
+			Value:       c.Subscriber.MessageNames,
+			Destination: &c.Subscriber.MessageNames,
+			EnvVars:     []string{"SUBSCRIBER_MESSAGE_NAMES"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "subscriber.filter",
+			Usage:       "Only subscribe to messages which match this subscription filter expression (subscribers need to use channel modes without publish or presence)",
+			Value:       c.Subscriber.Filter,
+			Destination: &c.Subscriber.Filter,
+			EnvVars:     []string{"SUBSCRIBER_FILTER"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "subscriber.rewind",
+			Usage:       "Attach to channels with rewind, either a number of messages (e.g. '10') or a duration (e.g. '30s')",
+			Value:       c.Subscriber.Rewind,
+			Destination: &c.Subscriber.Rewind,
+			EnvVars:     []string{"SUBSCRIBER_REWIND"},
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "subscriber.push-device.enabled",
 			Usage:       "Register and subscribe a push device",
@@ -104,6 +132,83 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Publisher.PublishInterval,
 			EnvVars:     []string{"PUBLISHER_PUBLISH_INTERVAL"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.rate-mode",
+			Usage:       "How often each user publishes to each channel, one of 'constant', 'poisson', 'burst', 'ramp' or 'steps'",
+			Value:       c.Publisher.RateMode,
+			Destination: &c.Publisher.RateMode,
+			EnvVars:     []string{"PUBLISHER_RATE_MODE"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "publisher.burst-size",
+			Usage:       "The number of messages published every publish interval when the rate mode is 'burst'",
+			Value:       c.Publisher.BurstSize,
+			Destination: &c.Publisher.BurstSize,
+			EnvVars:     []string{"PUBLISHER_BURST_SIZE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "publisher.ramp-end-interval",
+			Usage:       "The interval between publishes at the end of the ramp when the rate mode is 'ramp' (starting from the publish interval)",
+			Value:       c.Publisher.RampEndInterval,
+			Destination: &c.Publisher.RampEndInterval,
+			EnvVars:     []string{"PUBLISHER_RAMP_END_INTERVAL"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "publisher.ramp-duration",
+			Usage:       "How long to ramp the publish rate for from the start of the load test when the rate mode is 'ramp'",
+			Value:       c.Publisher.RampDuration,
+			Destination: &c.Publisher.RampDuration,
+			EnvVars:     []string{"PUBLISHER_RAMP_DURATION"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.steps",
+			Usage:       "The schedule of publish intervals when the rate mode is 'steps' (comma separated list of duration:interval, e.g. '30s:1s, 1m:500ms')",
+			Value:       c.Publisher.Steps,
+			Destination: &c.Publisher.Steps,
+			EnvVars:     []string{"PUBLISHER_STEPS"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "publisher.start-jitter",
+			Usage:       "The maximum random delay before each user starts publishing to each channel",
+			Value:       c.Publisher.StartJitter,
+			Destination: &c.Publisher.StartJitter,
+			EnvVars:     []string{"PUBLISHER_START_JITTER"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "publisher.batch-size",
+			Usage:       "The maximum number of messages to publish to a channel in a single publish call",
+			Value:       c.Publisher.BatchSize,
+			Destination: &c.Publisher.BatchSize,
+			EnvVars:     []string{"PUBLISHER_BATCH_SIZE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "publisher.batch-linger",
+			Usage:       "How long to wait for a batch to fill before publishing it (0 to only batch messages scheduled at the same time)",
+			Value:       c.Publisher.BatchLinger,
+			Destination: &c.Publisher.BatchLinger,
+			EnvVars:     []string{"PUBLISHER_BATCH_LINGER"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.message-name",
+			Usage:       "The name of published messages (a Go template which can include {{ .UserNumber }}, {{ .Channel }} and {{ .Seq }})",
+			Value:       c.Publisher.MessageName,
+			Destination: &c.Publisher.MessageName,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_NAME"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.message-extras",
+			Usage:       "The extras of published messages as a JSON object (a Go template with the same variables as publisher.message-name)",
+			Value:       c.Publisher.MessageExtras,
+			Destination: &c.Publisher.MessageExtras,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_EXTRAS"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.message-headers",
+			Usage:       "The headers of published messages as a JSON object, set as the headers field of the extras (a Go template with the same variables as publisher.message-name)",
+			Value:       c.Publisher.MessageHeaders,
+			Destination: &c.Publisher.MessageHeaders,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_HEADERS"},
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "publisher.push-enabled",
 			Usage:       "Publish onto a push-enabled channel",
@@ -118,6 +223,62 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Publisher.MessageSize,
 			EnvVars:     []string{"PUBLISHER_MESSAGE_SIZE"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.payload-type",
+			Usage:       "The type of message payloads, one of 'hex', 'binary', 'text', 'json' or 'files'",
+			Value:       c.Publisher.PayloadType,
+			Destination: &c.Publisher.PayloadType,
+			EnvVars:     []string{"PUBLISHER_PAYLOAD_TYPE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.payload-template",
+			Usage:       "The Go template used to render JSON payloads when the payload type is 'json'",
+			Value:       c.Publisher.PayloadTemplate,
+			Destination: &c.Publisher.PayloadTemplate,
+			EnvVars:     []string{"PUBLISHER_PAYLOAD_TEMPLATE"},
+		}),
+		altsrc.NewPathFlag(&cli.PathFlag{
+			Name:        "publisher.payload-dir",
+			Usage:       "The directory of files to use as payloads when the payload type is 'files'",
+			Value:       c.Publisher.PayloadDir,
+			Destination: &c.Publisher.PayloadDir,
+			EnvVars:     []string{"PUBLISHER_PAYLOAD_DIR"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.size-distribution",
+			Usage:       "The distribution of message sizes, one of 'fixed', 'uniform', 'normal' or 'weighted'",
+			Value:       c.Publisher.SizeDistribution,
+			Destination: &c.Publisher.SizeDistribution,
+			EnvVars:     []string{"PUBLISHER_SIZE_DISTRIBUTION"},
+		}),
+		altsrc.NewInt64Flag(&cli.Int64Flag{
+			Name:        "publisher.message-size-min",
+			Usage:       "The minimum message size when the size distribution is 'uniform'",
+			Value:       c.Publisher.MessageSizeMin,
+			Destination: &c.Publisher.MessageSizeMin,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_SIZE_MIN"},
+		}),
+		altsrc.NewInt64Flag(&cli.Int64Flag{
+			Name:        "publisher.message-size-max",
+			Usage:       "The maximum message size when the size distribution is 'uniform'",
+			Value:       c.Publisher.MessageSizeMax,
+			Destination: &c.Publisher.MessageSizeMax,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_SIZE_MAX"},
+		}),
+		altsrc.NewInt64Flag(&cli.Int64Flag{
+			Name:        "publisher.message-size-std-dev",
+			Usage:       "The standard deviation of message sizes when the size distribution is 'normal' (with a mean of publisher.message-size)",
+			Value:       c.Publisher.MessageSizeStdDev,
+			Destination: &c.Publisher.MessageSizeStdDev,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_SIZE_STD_DEV"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "publisher.message-sizes",
+			Usage:       "The message sizes and relative weights when the size distribution is 'weighted' (comma separated list of size:weight, e.g. '100:80, 1KiB:15, 64KiB:5')",
+			Value:       c.Publisher.MessageSizes,
+			Destination: &c.Publisher.MessageSizes,
+			EnvVars:     []string{"PUBLISHER_MESSAGE_SIZES"},
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "presence.enabled",
 			Usage:       "Run presence users",
@@ -132,6 +293,118 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Presence.Channels,
 			EnvVars:     []string{"PRESENCE_CHANNELS"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "presence.data",
+			Usage:       "A template for the presence data of each user (e.g. '{\"room\": {{ mod .UserNumber 4 }}}')",
+			Value:       c.Presence.Data,
+			Destination: &c.Presence.Data,
+			EnvVars:     []string{"PRESENCE_DATA"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "presence.update-interval",
+			Usage:       "The interval between presence data updates by each user (0 to not update)",
+			Value:       c.Presence.UpdateInterval,
+			Destination: &c.Presence.UpdateInterval,
+			EnvVars:     []string{"PRESENCE_UPDATE_INTERVAL"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "presence.churn-interval",
+			Usage:       "How long each user stays present before leaving and re-entering (0 to never leave)",
+			Value:       c.Presence.ChurnInterval,
+			Destination: &c.Presence.ChurnInterval,
+			EnvVars:     []string{"PRESENCE_CHURN_INTERVAL"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "presence.churn-absence",
+			Usage:       "How long each user stays absent after leaving before re-entering",
+			Value:       c.Presence.ChurnAbsence,
+			Destination: &c.Presence.ChurnAbsence,
+			EnvVars:     []string{"PRESENCE_CHURN_ABSENCE"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "presence-subscriber.enabled",
+			Usage:       "Run presence subscriber users",
+			Value:       c.PresenceSubscriber.Enabled,
+			Destination: &c.PresenceSubscriber.Enabled,
+			EnvVars:     []string{"PRESENCE_SUBSCRIBER_ENABLED"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "presence-subscriber.channels",
+			Usage:       "The channels each user should subscribe to presence events on (comma separated)",
+			Value:       c.PresenceSubscriber.Channels,
+			Destination: &c.PresenceSubscriber.Channels,
+			EnvVars:     []string{"PRESENCE_SUBSCRIBER_CHANNELS"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "presence-subscriber.check-interval",
+			Usage:       "The interval between checks of the presence set of each channel against the expected members (0 to not check)",
+			Value:       c.PresenceSubscriber.CheckInterval,
+			Destination: &c.PresenceSubscriber.CheckInterval,
+			EnvVars:     []string{"PRESENCE_SUBSCRIBER_CHECK_INTERVAL"},
+		}),
+		altsrc.NewInt64Flag(&cli.Int64Flag{
+			Name:        "presence-subscriber.users",
+			Usage:       "The total number of users across all workers used to compute the expected presence members (0 to use the members entered by each worker)",
+			Value:       c.PresenceSubscriber.Users,
+			Destination: &c.PresenceSubscriber.Users,
+			EnvVars:     []string{"PRESENCE_SUBSCRIBER_USERS"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "history.enabled",
+			Usage:       "Run history users",
+			Value:       c.History.Enabled,
+			Destination: &c.History.Enabled,
+			EnvVars:     []string{"HISTORY_ENABLED"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "history.channels",
+			Usage:       "The channels each user should query the history of (comma separated)",
+			Value:       c.History.Channels,
+			Destination: &c.History.Channels,
+			EnvVars:     []string{"HISTORY_CHANNELS"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "history.client",
+			Usage:       "The client to query history with, either 'rest' or 'realtime'",
+			Value:       c.History.Client,
+			Destination: &c.History.Client,
+			EnvVars:     []string{"HISTORY_CLIENT"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "history.interval",
+			Usage:       "The interval between history queries of each channel by each user",
+			Value:       c.History.Interval,
+			Destination: &c.History.Interval,
+			EnvVars:     []string{"HISTORY_INTERVAL"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "history.limit",
+			Usage:       "The maximum number of messages in each page of history",
+			Value:       c.History.Limit,
+			Destination: &c.History.Limit,
+			EnvVars:     []string{"HISTORY_LIMIT"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "history.direction",
+			Usage:       "The direction to query history in, either 'backwards' or 'forwards'",
+			Value:       c.History.Direction,
+			Destination: &c.History.Direction,
+			EnvVars:     []string{"HISTORY_DIRECTION"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "history.time-range",
+			Usage:       "Only query history for messages published within this duration of each query (0 to not limit the time range)",
+			Value:       c.History.TimeRange,
+			Destination: &c.History.TimeRange,
+			EnvVars:     []string{"HISTORY_TIME_RANGE"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "history.max-pages",
+			Usage:       "The maximum number of pages to fetch for each query (0 to fetch all pages)",
+			Value:       c.History.MaxPages,
+			Destination: &c.History.MaxPages,
+			EnvVars:     []string{"HISTORY_MAX_PAGES"},
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "standalone.enabled",
 			Aliases:     []string{"standalone", "s"},
@@ -154,6 +427,27 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Standalone.SpawnRate,
 			EnvVars:     []string{"STANDALONE_SPAWN_RATE"},
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "standalone.duration",
+			Usage:       "How long to run for when running in standalone mode before stopping and printing a summary (0 to run until interrupted)",
+			Value:       c.Standalone.Duration,
+			Destination: &c.Standalone.Duration,
+			EnvVars:     []string{"STANDALONE_DURATION"},
+		}),
+		altsrc.NewPathFlag(&cli.PathFlag{
+			Name:        "standalone.summary-file",
+			Usage:       "The path to write the summary to at the end of a standalone run",
+			Value:       c.Standalone.SummaryFile,
+			Destination: &c.Standalone.SummaryFile,
+			EnvVars:     []string{"STANDALONE_SUMMARY_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "standalone.summary-format",
+			Usage:       "The format of the summary file, either 'json' or 'markdown'",
+			Value:       c.Standalone.SummaryFormat,
+			Destination: &c.Standalone.SummaryFormat,
+			EnvVars:     []string{"STANDALONE_SUMMARY_FORMAT"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "locust.host",
 			Usage:       "Locust master host",
@@ -203,6 +497,62 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Ably.ChannelModes,
 			EnvVars:     []string{"ABLY_CHANNEL_MODES"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "ably.protocol",
+			Usage:       "The wire protocol used by Ably clients, either 'json' or 'msgpack'",
+			Value:       c.Ably.Protocol,
+			Destination: &c.Ably.Protocol,
+			EnvVars:     []string{"ABLY_PROTOCOL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "ably.cipher-key",
+			Usage:       "A base64 encoded 128 or 256 bit key to encrypt channels with using AES-128 or AES-256 (leave empty to not encrypt channels)",
+			Value:       c.Ably.CipherKey,
+			Destination: &c.Ably.CipherKey,
+			EnvVars:     []string{"ABLY_CIPHER_KEY"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "ably.auth-mode",
+			Usage:       "How users authenticate, either 'key', 'token-request', 'jwt', 'auth-url' or 'auth-callback'",
+			Value:       c.Ably.AuthMode,
+			Destination: &c.Ably.AuthMode,
+			EnvVars:     []string{"ABLY_AUTH_MODE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "ably.auth-url",
+			Usage:       "The URL to request tokens from when using the 'auth-url' or 'auth-callback' auth modes",
+			Value:       c.Ably.AuthURL,
+			Destination: &c.Ably.AuthURL,
+			EnvVars:     []string{"ABLY_AUTH_URL"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "ably.token-ttl",
+			Usage:       "The TTL of tokens issued to users when using token auth",
+			Value:       c.Ably.TokenTTL,
+			Destination: &c.Ably.TokenTTL,
+			EnvVars:     []string{"ABLY_TOKEN_TTL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "ably.client-id",
+			Usage:       "A template for the clientId of each user (e.g. 'user-{{ .UserNumber }}')",
+			Value:       c.Ably.ClientID,
+			Destination: &c.Ably.ClientID,
+			EnvVars:     []string{"ABLY_CLIENT_ID"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "ably.capability",
+			Usage:       "A template for the JSON capability of each user (e.g. '{\"user-{{ .UserNumber }}\": [\"*\"]}')",
+			Value:       c.Ably.Capability,
+			Destination: &c.Ably.Capability,
+			EnvVars:     []string{"ABLY_CAPABILITY"},
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:        "ably.token-deny-fraction",
+			Usage:       "The fraction of users whose token renewals are denied (between 0 and 1)",
+			Value:       c.Ably.TokenDenyFraction,
+			Destination: &c.Ably.TokenDenyFraction,
+			EnvVars:     []string{"ABLY_TOKEN_DENY_FRACTION"},
+		}),
 		altsrc.NewPathFlag(&cli.PathFlag{
 			Name:        "perf.cpu-profile-dir",
 			Usage:       "The directory path to write the pprof cpu profile",
@@ -217,6 +567,97 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Perf.S3Bucket,
 			EnvVars:     []string{"PERF_S3_BUCKET"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "perf.s3-endpoint",
+			Usage:       "A custom endpoint for an S3-compatible service (e.g. MinIO) to upload pprof data to",
+			Value:       c.Perf.S3Endpoint,
+			Destination: &c.Perf.S3Endpoint,
+			EnvVars:     []string{"PERF_S3_ENDPOINT"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "perf.s3-region",
+			Usage:       "The region of the s3 bucket (defaults to the AWS environment)",
+			Value:       c.Perf.S3Region,
+			Destination: &c.Perf.S3Region,
+			EnvVars:     []string{"PERF_S3_REGION"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "perf.s3-force-path-style",
+			Usage:       "Use path-style addressing for s3 requests, typically required by S3-compatible services",
+			Value:       c.Perf.S3ForcePathStyle,
+			Destination: &c.Perf.S3ForcePathStyle,
+			EnvVars:     []string{"PERF_S3_FORCE_PATH_STYLE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "perf.sink",
+			Usage:       "Where to upload pprof data to, either 'local', 's3' or 'http' (defaults to 's3' if perf.s3-bucket is set)",
+			Value:       c.Perf.Sink,
+			Destination: &c.Perf.Sink,
+			EnvVars:     []string{"PERF_SINK"},
+		}),
+		altsrc.NewPathFlag(&cli.PathFlag{
+			Name:        "perf.sink-dir",
+			Usage:       "The directory path to copy pprof data to when using the 'local' sink",
+			Value:       c.Perf.SinkDir,
+			Destination: &c.Perf.SinkDir,
+			EnvVars:     []string{"PERF_SINK_DIR"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "perf.sink-url",
+			Usage:       "The base URL to PUT pprof data to when using the 'http' sink",
+			Value:       c.Perf.SinkURL,
+			Destination: &c.Perf.SinkURL,
+			EnvVars:     []string{"PERF_SINK_URL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "perf.key-prefix",
+			Usage:       "A template for the prefix of uploaded pprof data keys, with .Hostname, .WorkerNumber and .TestID available",
+			Value:       c.Perf.KeyPrefix,
+			Destination: &c.Perf.KeyPrefix,
+			EnvVars:     []string{"PERF_KEY_PREFIX"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "perf.heap-profile",
+			Usage:       "Write a pprof heap profile to the perf profile dir",
+			Value:       c.Perf.HeapProfile,
+			Destination: &c.Perf.HeapProfile,
+			EnvVars:     []string{"PERF_HEAP_PROFILE"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "perf.allocs-profile",
+			Usage:       "Write a pprof allocs profile to the perf profile dir",
+			Value:       c.Perf.AllocsProfile,
+			Destination: &c.Perf.AllocsProfile,
+			EnvVars:     []string{"PERF_ALLOCS_PROFILE"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "perf.goroutine-profile",
+			Usage:       "Write a pprof goroutine profile to the perf profile dir",
+			Value:       c.Perf.GoroutineProfile,
+			Destination: &c.Perf.GoroutineProfile,
+			EnvVars:     []string{"PERF_GOROUTINE_PROFILE"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "perf.mutex-profile",
+			Usage:       "Enable mutex profiling and write a pprof mutex profile to the perf profile dir",
+			Value:       c.Perf.MutexProfile,
+			Destination: &c.Perf.MutexProfile,
+			EnvVars:     []string{"PERF_MUTEX_PROFILE"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "perf.block-profile",
+			Usage:       "Enable block profiling and write a pprof block profile to the perf profile dir",
+			Value:       c.Perf.BlockProfile,
+			Destination: &c.Perf.BlockProfile,
+			EnvVars:     []string{"PERF_BLOCK_PROFILE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "perf.snapshot-interval",
+			Usage:       "The interval between timestamped snapshots of the non-cpu profiles (0 to only write them when the load test stops)",
+			Value:       c.Perf.SnapshotInterval,
+			Destination: &c.Perf.SnapshotInterval,
+			EnvVars:     []string{"PERF_SNAPSHOT_INTERVAL"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "log.level",
 			Usage:       "The log level",
@@ -252,6 +693,76 @@ func (c *Config) Flags() []cli.Flag {
 			Destination: &c.Redis.WorkerNumberKey,
 			EnvVars:     []string{"REDIS_WORKER_NUMBER_KEY"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "thresholds.rules",
+			Usage:       "Pass/fail thresholds for the load test (comma separated, e.g. 'subscribe.p99 < 150ms, publish.failure-ratio < 0.1%')",
+			Value:       c.Thresholds.Rules,
+			Destination: &c.Thresholds.Rules,
+			EnvVars:     []string{"THRESHOLDS_RULES"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "thresholds.check-interval",
+			Usage:       "The interval between threshold checks whilst a load test is running (0 to only check at the end)",
+			Value:       c.Thresholds.CheckInterval,
+			Destination: &c.Thresholds.CheckInterval,
+			EnvVars:     []string{"THRESHOLDS_CHECK_INTERVAL"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "thresholds.abort-on-breach",
+			Usage:       "Stop the load test as soon as a threshold is breached",
+			Value:       c.Thresholds.AbortOnBreach,
+			Destination: &c.Thresholds.AbortOnBreach,
+			EnvVars:     []string{"THRESHOLDS_ABORT_ON_BREACH"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "results.file",
+			Usage:       "The path to write per-interval results to (a Go template which can include {{ .WorkerNumber }})",
+			Value:       c.Results.File,
+			Destination: &c.Results.File,
+			EnvVars:     []string{"RESULTS_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "results.format",
+			Usage:       "The format of the results file, either 'csv' or 'jsonl'",
+			Value:       c.Results.Format,
+			Destination: &c.Results.Format,
+			EnvVars:     []string{"RESULTS_FORMAT"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "results.interval",
+			Usage:       "The interval between rows written to the results file",
+			Value:       c.Results.Interval,
+			Destination: &c.Results.Interval,
+			EnvVars:     []string{"RESULTS_INTERVAL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "token-server.addr",
+			Usage:       "The address the token-server command listens on",
+			Value:       c.TokenServer.Addr,
+			Destination: &c.TokenServer.Addr,
+			EnvVars:     []string{"TOKEN_SERVER_ADDR"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "http.enabled",
+			Usage:       "Run an HTTP server exposing pprof, status and health check endpoints",
+			Value:       c.HTTP.Enabled,
+			Destination: &c.HTTP.Enabled,
+			EnvVars:     []string{"HTTP_ENABLED"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http.addr",
+			Usage:       "The TCP address for the HTTP server to listen on",
+			Value:       c.HTTP.Addr,
+			Destination: &c.HTTP.Addr,
+			EnvVars:     []string{"HTTP_ADDR"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "prometheus.enabled",
+			Usage:       "Export Prometheus metrics on the HTTP server's /metrics endpoint (starts the HTTP server)",
+			Value:       c.Prometheus.Enabled,
+			Destination: &c.Prometheus.Enabled,
+			EnvVars:     []string{"PROMETHEUS_ENABLED"},
+		}),
 	}
 }
 
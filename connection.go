@@ -0,0 +1,306 @@
+package ablyboomer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+// connectionWatcher records stats about the lifecycle of an Ably connection
+// from its state changes:
+//
+// connect:              the time from connecting until the connection is first
+//                       CONNECTED (or FAILED)
+//
+// reconnect:            the time from being DISCONNECTED until being CONNECTED
+//                       again
+//
+// reauth:               the time from requesting a renewed token until the
+//                       connection is CONNECTED or UPDATED (see tokenRenewals)
+//
+// connection<State>:    a count of transitions to each state (e.g.
+//                       "connectionDisconnected"), with transitions to FAILED
+//                       and SUSPENDED recorded as failures with the reason
+//                       grouped by Ably error code (see stateReasonError)
+//
+// The time the connection spends CONNECTED is also tracked in
+// connectionUptimes, and the time it last became CONNECTED in connectedAt so
+// that the time publishes spend queued can be measured (see
+// ablyClient.Publish).
+type connectionWatcher struct {
+	recorder       Recorder
+	renewals       *tokenRenewals
+	uptime         *connectionUptime
+	started        int64
+	connected      bool
+	disconnectedAt int64
+
+	// connectedAt is the time the connection last became CONNECTED, or 0
+	// if it isn't CONNECTED
+	connectedAtMtx sync.Mutex
+	connectedAt    int64
+
+	// firstErr receives nil when the connection is first CONNECTED, or the
+	// reason if it becomes FAILED before that
+	firstErr     chan error
+	firstErrOnce sync.Once
+
+	// done is closed once the connection is CLOSED or FAILED
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// newConnectionWatcher returns a connectionWatcher for a connection which is
+// about to connect, which uses the given tokenRenewals (if not nil) to
+// measure reauth latency.
+func newConnectionWatcher(recorder Recorder, renewals *tokenRenewals) *connectionWatcher {
+	return &connectionWatcher{
+		recorder: recorder,
+		renewals: renewals,
+		uptime:   connectionUptimes.start(time.Now()),
+		started:  timeNow(),
+		firstErr: make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// handle records stats for the given connection state change.
+func (c *connectionWatcher) handle(state ably.ConnectionStateChange) {
+	if state.Event != ably.ConnectionEventUpdate {
+		c.uptime.transition(state.Current == ably.ConnectionStateConnected, time.Now())
+		c.setConnected(state.Current == ably.ConnectionStateConnected)
+		c.recordState(state)
+	}
+
+	switch state.Event {
+	case ably.ConnectionEventConnected:
+		if !c.connected {
+			c.connected = true
+			c.recorder.RecordSuccess("connect", timeNow()-c.started, 0)
+			c.firstErrOnce.Do(func() { c.firstErr <- nil })
+		}
+		if c.disconnectedAt > 0 {
+			reconnectLatency := timeNow() - c.disconnectedAt
+			c.recorder.RecordSuccess("reconnect", reconnectLatency, 0)
+			c.disconnectedAt = 0
+		}
+		c.reauthDone(nil)
+	case ably.ConnectionEventUpdate:
+		c.reauthDone(nil)
+	case ably.ConnectionEventDisconnected, ably.ConnectionEventSuspended:
+		if state.Event == ably.ConnectionEventDisconnected {
+			c.disconnectedAt = timeNow()
+		}
+		if state.Reason != nil {
+			c.reauthDone(state.Reason)
+		}
+	case ably.ConnectionEventFailed:
+		reason := connectionStateError(state)
+		if !c.connected {
+			c.recorder.RecordFailure("connect", timeNow()-c.started, reason)
+		}
+		c.firstErrOnce.Do(func() {
+			if state.Reason != nil {
+				c.firstErr <- state.Reason
+			} else {
+				c.firstErr <- reason
+			}
+		})
+		c.reauthDone(reason)
+		// FAILED is a terminal state, so stop watching
+		c.stop()
+	case ably.ConnectionEventClosed:
+		c.stop()
+	}
+}
+
+// setConnected sets connectedAt to the current time if the connection is
+// CONNECTED, or to 0 if it isn't.
+func (c *connectionWatcher) setConnected(connected bool) {
+	c.connectedAtMtx.Lock()
+	defer c.connectedAtMtx.Unlock()
+	if !connected {
+		c.connectedAt = 0
+	} else if c.connectedAt == 0 {
+		c.connectedAt = timeNow()
+	}
+}
+
+// lastConnected returns the time the connection last became CONNECTED, or 0
+// if it isn't CONNECTED.
+func (c *connectionWatcher) lastConnected() int64 {
+	c.connectedAtMtx.Lock()
+	defer c.connectedAtMtx.Unlock()
+	return c.connectedAt
+}
+
+// recordState counts the transition to the given state, recording
+// transitions to FAILED or SUSPENDED as failures.
+func (c *connectionWatcher) recordState(state ably.ConnectionStateChange) {
+	name := "connection" + strings.Title(strings.ToLower(state.Current.String()))
+	switch state.Current {
+	case ably.ConnectionStateFailed, ably.ConnectionStateSuspended:
+		c.recorder.RecordFailure(name, 0, connectionStateError(state))
+	default:
+		c.recorder.RecordSuccess(name, 0, 0)
+	}
+}
+
+// connectionStateError returns the reason for the given connection state
+// change as a stateReasonError.
+func connectionStateError(state ably.ConnectionStateChange) error {
+	return &stateReasonError{subject: "connection", state: state.Current.String(), reason: state.Reason}
+}
+
+// reauthDone records the reauth latency if a token renewal is in progress,
+// as a failure if err is not nil.
+func (c *connectionWatcher) reauthDone(err error) {
+	if c.renewals == nil {
+		return
+	}
+	if elapsedTime, ok := c.renewals.done(); ok {
+		if err == nil {
+			c.recorder.RecordSuccess("reauth", elapsedTime, 0)
+		} else {
+			c.recorder.RecordFailure("reauth", elapsedTime, err)
+		}
+	}
+}
+
+// stop stops tracking the connection's uptime and closes the done channel.
+func (c *connectionWatcher) stop() {
+	c.doneOnce.Do(func() {
+		c.uptime.stop(time.Now())
+		close(c.done)
+	})
+}
+
+// stateReasonError is the reason for a connection or channel state change,
+// whose message only includes the Ably error code and status code of the
+// reason so that failures are grouped by error code rather than by the full
+// error message (which may include connection or channel specific details).
+type stateReasonError struct {
+	subject string
+	state   string
+	reason  *ably.ErrorInfo
+}
+
+// Error implements the error interface.
+func (e *stateReasonError) Error() string {
+	state := strings.ToLower(e.state)
+	if e.reason == nil {
+		return fmt.Sprintf("%s %s", e.subject, state)
+	}
+	return fmt.Sprintf("%s %s: error code %d (status code %d)", e.subject, state, e.reason.Code, e.reason.StatusCode)
+}
+
+// Unwrap returns the underlying Ably error so that the error code can be
+// determined by errorCode.
+func (e *stateReasonError) Unwrap() error {
+	if e.reason == nil {
+		return nil
+	}
+	return e.reason
+}
+
+// connectionUptimes tracks the time spent CONNECTED by all Ably connections
+// of the worker process, and is reset when each load test starts.
+var connectionUptimes = newUptimeTracker()
+
+// uptimeTracker tracks the total time a set of connections spend CONNECTED.
+type uptimeTracker struct {
+	mtx       sync.Mutex
+	connected time.Duration
+	total     time.Duration
+	active    map[*connectionUptime]struct{}
+}
+
+// newUptimeTracker returns a new uptimeTracker.
+func newUptimeTracker() *uptimeTracker {
+	return &uptimeTracker{active: make(map[*connectionUptime]struct{})}
+}
+
+// start starts tracking a new connection which isn't yet CONNECTED.
+func (t *uptimeTracker) start(now time.Time) *connectionUptime {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	u := &connectionUptime{tracker: t, since: now}
+	t.active[u] = struct{}{}
+	return u
+}
+
+// reset forgets the time tracked so far, so that the percentage only
+// includes time from now on.
+func (t *uptimeTracker) reset(now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.connected = 0
+	t.total = 0
+	for u := range t.active {
+		u.since = now
+	}
+}
+
+// percent returns the percentage of the total time tracked connections have
+// been CONNECTED, or 0 if no time has been tracked.
+func (t *uptimeTracker) percent(now time.Time) float64 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	connected, total := t.connected, t.total
+	for u := range t.active {
+		d := now.Sub(u.since)
+		total += d
+		if u.connected {
+			connected += d
+		}
+	}
+	if total <= 0 {
+		return 0
+	}
+	return 100 * float64(connected) / float64(total)
+}
+
+// connectionUptime tracks the time a single connection spends CONNECTED.
+type connectionUptime struct {
+	tracker   *uptimeTracker
+	since     time.Time
+	connected bool
+	stopped   bool
+}
+
+// transition records that the connection changed state at the given time.
+func (u *connectionUptime) transition(connected bool, now time.Time) {
+	u.tracker.mtx.Lock()
+	defer u.tracker.mtx.Unlock()
+	if u.stopped {
+		return
+	}
+	u.flush(now)
+	u.connected = connected
+}
+
+// stop stops tracking the connection at the given time.
+func (u *connectionUptime) stop(now time.Time) {
+	u.tracker.mtx.Lock()
+	defer u.tracker.mtx.Unlock()
+	if u.stopped {
+		return
+	}
+	u.flush(now)
+	u.stopped = true
+	delete(u.tracker.active, u)
+}
+
+// flush adds the time since the last transition to the tracker's totals, and
+// must be called with the tracker's mutex held.
+func (u *connectionUptime) flush(now time.Time) {
+	d := now.Sub(u.since)
+	u.tracker.total += d
+	if u.connected {
+		u.tracker.connected += d
+	}
+	u.since = now
+}
@@ -0,0 +1,120 @@
+package ablyboomer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+// TestConnectionWatcher tests recording connection lifecycle stats from
+// connection state changes.
+func TestConnectionWatcher(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	watcher := newConnectionWatcher(recorder, nil)
+	reason := func(code ably.ErrorCode, statusCode int) *ably.ErrorInfo {
+		return &ably.ErrorInfo{Code: code, StatusCode: statusCode}
+	}
+	for _, change := range []ably.ConnectionStateChange{
+		{Event: ably.ConnectionEventConnecting, Current: ably.ConnectionStateConnecting},
+		{Event: ably.ConnectionEventConnected, Current: ably.ConnectionStateConnected},
+		{Event: ably.ConnectionEventDisconnected, Current: ably.ConnectionStateDisconnected, Reason: reason(80003, 0)},
+		{Event: ably.ConnectionEventSuspended, Current: ably.ConnectionStateSuspended, Reason: reason(80002, 0)},
+		{Event: ably.ConnectionEventSuspended, Current: ably.ConnectionStateSuspended, Reason: reason(80002, 0)},
+		{Event: ably.ConnectionEventConnected, Current: ably.ConnectionStateConnected},
+		{Event: ably.ConnectionEventUpdate, Current: ably.ConnectionStateConnected},
+		{Event: ably.ConnectionEventFailed, Current: ably.ConnectionStateFailed, Reason: reason(40142, 401)},
+	} {
+		watcher.handle(change)
+	}
+
+	select {
+	case err := <-watcher.firstErr:
+		if err != nil {
+			t.Fatalf("expected the first connection to succeed, got %v", err)
+		}
+	default:
+		t.Fatal("expected the first connection result to be sent")
+	}
+	select {
+	case <-watcher.done:
+	default:
+		t.Fatal("expected the watcher to be done once FAILED")
+	}
+	if connectedAt := watcher.lastConnected(); connectedAt != 0 {
+		t.Fatalf("expected no connected time once FAILED, got %d", connectedAt)
+	}
+
+	// check the initial connection is only recorded once
+	if stats := taskStats(recorder, "connect"); stats.Count != 1 || stats.Failures != 0 {
+		t.Fatalf("expected 1 connect success, got %+v", stats)
+	}
+	if stats := taskStats(recorder, "reconnect"); stats.Count != 1 {
+		t.Fatalf("expected 1 reconnect success, got %+v", stats)
+	}
+
+	// check transitions are counted per state, excluding updates
+	for name, count := range map[string]int64{
+		"connectionConnecting":   1,
+		"connectionConnected":    2,
+		"connectionDisconnected": 1,
+	} {
+		if stats := taskStats(recorder, name); stats.Count != count {
+			t.Fatalf("expected %d %s successes, got %d", count, name, stats.Count)
+		}
+	}
+
+	// check SUSPENDED and FAILED reasons are grouped by error code
+	stats := taskStats(recorder, "connectionSuspended")
+	if msg := "connection suspended: error code 80002 (status code 0)"; stats.Failures != 2 || stats.FailuresByMessage[msg] != 2 {
+		t.Fatalf("expected 2 %q failures, got %v", msg, stats.FailuresByMessage)
+	}
+	stats = taskStats(recorder, "connectionFailed")
+	if msg := "connection failed: error code 40142 (status code 401)"; stats.Failures != 1 || stats.FailuresByMessage[msg] != 1 {
+		t.Fatalf("expected 1 %q failure, got %v", msg, stats.FailuresByMessage)
+	}
+	if code := errorCode(&stateReasonError{subject: "connection", state: "FAILED", reason: reason(40142, 401)}); code != "40142" {
+		t.Fatalf("expected error code 40142, got %q", code)
+	}
+}
+
+// TestUptimeTracker tests calculating the percentage of time connections
+// spend CONNECTED.
+func TestUptimeTracker(t *testing.T) {
+	tracker := newUptimeTracker()
+	now := time.Unix(1600000000, 0)
+	if percent := tracker.percent(now); percent != 0 {
+		t.Fatalf("expected 0%% uptime with no connections, got %v", percent)
+	}
+
+	// connection 1 connects after 1s, disconnects after 3s and is
+	// stopped after 4s
+	u1 := tracker.start(now)
+	u1.transition(true, now.Add(1*time.Second))
+	u1.transition(false, now.Add(3*time.Second))
+	u1.stop(now.Add(4 * time.Second))
+
+	// connection 2 connects after 2s and is still connected
+	u2 := tracker.start(now)
+	u2.transition(true, now.Add(2*time.Second))
+
+	// connected for 2s + 6s out of 4s + 8s
+	if percent := tracker.percent(now.Add(8 * time.Second)); percent < 66.6 || percent > 66.7 {
+		t.Fatalf("expected 66.67%% uptime, got %v", percent)
+	}
+
+	// check transitions after stopping are ignored
+	u1.transition(true, now.Add(5*time.Second))
+	u2.stop(now.Add(8 * time.Second))
+	if percent := tracker.percent(now.Add(20 * time.Second)); percent < 66.6 || percent > 66.7 {
+		t.Fatalf("expected 66.67%% uptime, got %v", percent)
+	}
+
+	// check resetting only includes time from then on
+	u3 := tracker.start(now.Add(20 * time.Second))
+	tracker.reset(now.Add(25 * time.Second))
+	u3.transition(true, now.Add(27*time.Second))
+	if percent := tracker.percent(now.Add(35 * time.Second)); percent != 80 {
+		t.Fatalf("expected 80%% uptime, got %v", percent)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/ably/ably-boomer
 go 1.14
 
 require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
 	github.com/StackExchange/wmi v0.0.0-20190523213315-cbe66965904d // indirect
 	github.com/ably/ably-go v1.2.2-0.20211016060629-9b3bbe603675
 	github.com/asaskevich/EventBus v0.0.0-20200428142821-4fc0642a29f3 // indirect
@@ -11,13 +12,12 @@ require (
 	github.com/docker/go-units v0.4.0
 	github.com/go-ole/go-ole v1.2.4 // indirect
 	github.com/go-redis/redis/v8 v8.4.8
-	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/google/uuid v1.1.1 // indirect
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1
-	github.com/kr/pretty v0.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.7 // indirect
 	github.com/myzhan/boomer v0.0.0-20210122030331-a9eddc3e0219
 	github.com/olekukonko/tablewriter v0.0.4 // indirect
+	github.com/prometheus/client_golang v1.11.0
 	github.com/r3labs/sse v0.0.0-20200828202401-10175c338a0a
 	github.com/shirou/gopsutil v2.20.5+incompatible // indirect
 	github.com/urfave/cli/v2 v2.2.0
@@ -25,7 +25,6 @@ require (
 	github.com/zeromq/gomq v0.0.0-20201031135124-cef4e507bb8e // indirect
 	github.com/zeromq/gomq/zmtp v0.0.0-20201031135124-cef4e507bb8e // indirect
 	go.uber.org/atomic v1.6.0
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 	golang.org/x/tools v0.0.0-20191029190741-b9c20aec41a5 // indirect
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
@@ -0,0 +1,173 @@
+package ablyboomer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-go/ably"
+	"golang.org/x/sync/errgroup"
+)
+
+// Supported values for conf.History.Client.
+const (
+	HistoryClientREST     = "rest"
+	HistoryClientRealtime = "realtime"
+)
+
+// HistoryClient is an optional interface implemented by Clients which can
+// query channel history, and is required to run history tasks when
+// conf.History.Client is "realtime".
+type HistoryClient interface {
+	// History returns a request for the history of the given channel.
+	History(channel string, opts ...ably.HistoryOption) ably.HistoryRequest
+}
+
+// historyFunc returns a request for the history of the given channel.
+type historyFunc func(channel string, opts ...ably.HistoryOption) ably.HistoryRequest
+
+// validateHistoryConfig checks that the history client, limit and direction
+// are valid.
+func validateHistoryConfig(conf *config.HistoryConfig) error {
+	switch conf.Client {
+	case HistoryClientREST, HistoryClientRealtime:
+	default:
+		return fmt.Errorf("unknown history client: %q", conf.Client)
+	}
+	switch ably.Direction(conf.Direction) {
+	case ably.Backwards, ably.Forwards:
+	default:
+		return fmt.Errorf("unknown history direction: %q", conf.Direction)
+	}
+	if conf.Limit < 1 {
+		return fmt.Errorf("invalid history limit: %d", conf.Limit)
+	}
+	if conf.Interval <= 0 {
+		return fmt.Errorf("invalid history interval: %v", conf.Interval)
+	}
+	return nil
+}
+
+// runHistory runs a history task which renders the channel names using the
+// given user number and queries the history of each of them every
+// conf.History.Interval, using either a REST client (authenticated as the
+// user, see authenticator.clientOptions) or the user's realtime client
+// depending on conf.History.Client.
+//
+// The latency of each query is recorded as "history" and the latency of each
+// page as "historyPage", with the size being the total size of the message
+// data, so the mean number of pages per query is the ratio of their counts.
+func (l *loadTest) runHistory(ctx context.Context, client Client, userNum int64) error {
+	conf := l.w.Conf()
+	channels := renderChannels(l.historyChannels, userNum)
+
+	var history historyFunc
+	switch conf.History.Client {
+	case HistoryClientRealtime:
+		historyClient, ok := client.(HistoryClient)
+		if !ok {
+			err := fmt.Errorf("client %q does not support history", conf.Client)
+			l.w.recorder.RecordFailure("history", 0, err)
+			return err
+		}
+		history = historyClient.History
+	default:
+		// authenticate the REST client as the user, so that it uses the
+		// user's auth mode, clientId and capability
+		opts, _, err := l.auth.clientOptions(userNum, l.w.recorder)
+		if err != nil {
+			l.log.Debug("error getting REST client options", "err", err)
+			l.w.recorder.RecordFailure("createREST", 0, err)
+			return err
+		}
+		rest, err := ably.NewREST(opts...)
+		if err != nil {
+			l.log.Debug("error creating a REST client", "err", err)
+			l.w.recorder.RecordFailure("createREST", 0, err)
+			return err
+		}
+		var channelOpts []ably.ChannelOption
+		if key, _ := conf.Ably.DecodeCipherKey(); key != nil {
+			channelOpts = append(channelOpts, ably.ChannelWithCipherKey(key))
+		}
+		history = func(channel string, o ...ably.HistoryOption) ably.HistoryRequest {
+			return rest.Channels.Get(channel, channelOpts...).History(o...)
+		}
+	}
+
+	l.log.Debug("starting history", "channels", channels, "client", conf.History.Client, "interval", conf.History.Interval)
+
+	errG, ctx := errgroup.WithContext(ctx)
+	for i := range channels {
+		channel := channels[i]
+		errG.Go(func() error {
+			// wait a random amount of time so that users don't all
+			// query history at the same time
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(conf.History.Interval)))):
+			case <-ctx.Done():
+				return nil
+			}
+			ticker := time.NewTicker(conf.History.Interval)
+			defer ticker.Stop()
+			for {
+				l.queryHistory(ctx, history, channel, &conf.History)
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					l.log.Debug("history stopped", "channel", channel)
+					return nil
+				}
+			}
+		})
+	}
+	return errG.Wait()
+}
+
+// queryHistory queries the history of the given channel, fetching up to
+// conf.MaxPages pages (or all pages if MaxPages is 0).
+func (l *loadTest) queryHistory(ctx context.Context, history historyFunc, channel string, conf *config.HistoryConfig) {
+	opts := []ably.HistoryOption{
+		ably.HistoryWithLimit(conf.Limit),
+		ably.HistoryWithDirection(ably.Direction(conf.Direction)),
+	}
+	if conf.TimeRange > 0 {
+		now := time.Now()
+		opts = append(opts, ably.HistoryWithStart(now.Add(-conf.TimeRange)), ably.HistoryWithEnd(now))
+	}
+
+	l.log.Debug("querying history", "channel", channel)
+	startTime := timeNow()
+	pageStartTime := startTime
+	var numPages int
+	var size int64
+	pages, err := history(channel, opts...).Pages(ctx)
+	if err == nil {
+		for (conf.MaxPages <= 0 || numPages < conf.MaxPages) && pages.Next(ctx) {
+			numPages++
+			var pageSize int64
+			for _, msg := range pages.Items() {
+				if _, n, err := decodeMessage(msg.Data); err == nil {
+					pageSize += n
+				}
+			}
+			size += pageSize
+			l.w.recorder.RecordSuccess("historyPage", timeNow()-pageStartTime, pageSize)
+			pageStartTime = timeNow()
+		}
+		err = pages.Err()
+	}
+	elapsedTime := timeNow() - startTime
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		l.log.Debug("history query canceled", "channel", channel)
+	} else if err != nil {
+		l.log.Debug("error querying history", "channel", channel, "elapsedTime", elapsedTime, "err", err)
+		l.w.recorder.RecordFailure("history", elapsedTime, err)
+	} else {
+		l.log.Debug("queried history", "channel", channel, "elapsedTime", elapsedTime, "pages", numPages)
+		l.w.recorder.RecordSuccess("history", elapsedTime, size)
+	}
+}
@@ -0,0 +1,35 @@
+package ablyboomer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// TestValidateHistoryConfig tests validating the history client, direction,
+// limit and interval.
+func TestValidateHistoryConfig(t *testing.T) {
+	valid := config.Default().History
+	if err := validateHistoryConfig(&valid); err != nil {
+		t.Fatalf("expected default history config to be valid, got %v", err)
+	}
+	valid.Client = HistoryClientRealtime
+	valid.Direction = "forwards"
+	if err := validateHistoryConfig(&valid); err != nil {
+		t.Fatalf("expected realtime forwards history config to be valid, got %v", err)
+	}
+
+	for _, modify := range []func(*config.HistoryConfig){
+		func(c *config.HistoryConfig) { c.Client = "grpc" },
+		func(c *config.HistoryConfig) { c.Direction = "sideways" },
+		func(c *config.HistoryConfig) { c.Limit = 0 },
+		func(c *config.HistoryConfig) { c.Interval = -time.Second },
+	} {
+		conf := config.Default().History
+		modify(&conf)
+		if err := validateHistoryConfig(&conf); err == nil {
+			t.Fatalf("expected an error for %+v", conf)
+		}
+	}
+}
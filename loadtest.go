@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-boomer/perf"
 	"github.com/ably/ably-go/ably"
 	"github.com/inconshreveable/log15"
 	"go.uber.org/atomic"
@@ -22,15 +23,29 @@ import (
 // loadTest represents a running Locust load test and is used by the Worker to
 // spawn its requested number of users.
 type loadTest struct {
-	w                  *Worker
-	newClientFunc      NewClientFunc
-	subscriberChannels *template.Template
-	publisherChannels  *template.Template
-	presenceChannels   *template.Template
-	userCounter        *atomic.Int64
-	users              sync.WaitGroup
-	stopC              chan struct{}
-	log                log15.Logger
+	w                          *Worker
+	newClientFunc              NewClientFunc
+	subscriberChannels         *template.Template
+	publisherChannels          *template.Template
+	presenceChannels           *template.Template
+	presenceData               *template.Template
+	presenceSubscriberChannels *template.Template
+	historyChannels            *template.Template
+	publishRate                publishRate
+	payload                    payloadGenerator
+	payloadSize                sizeDistribution
+	messageTemplates           *messageTemplates
+	auth                       *authenticator
+	started                    time.Time
+	userCounter                *atomic.Int64
+	users                      sync.WaitGroup
+	activeUsers                atomic.Int64
+	tasksMtx                   sync.Mutex
+	activeTasks                map[string]int64
+	presenceMembers            presenceMembers
+	perf                       *perf.Perf
+	stopC                      chan struct{}
+	log                        log15.Logger
 }
 
 // runUser runs a single Locust user that runs one or more tasks, and returns
@@ -42,18 +57,31 @@ type loadTest struct {
 // subscriber: subscribe to the channels specified in conf.Subscriber.Channels
 //             if conf.Subscriber.Enabled is true (see loadTest.runSubscriber).
 //
-// publisher:  publish a message every conf.Publisher.PublishInterval to each
-//             of the channels specified in conf.Publisher.Channels if
-//             conf.Publisher.Enabled is true (see loadTest.runPublisher).
+// publisher:  publish messages at the rate configured by
+//             conf.Publisher.RateMode to each of the channels specified in
+//             conf.Publisher.Channels if conf.Publisher.Enabled is true (see
+//             loadTest.runPublisher).
 //
 // presence:   enter the channels specified in conf.Presence.Channels if
 //             conf.Presence.Enabled is true (see loadTest.runPresence).
 //
+// presence subscriber:
+//             subscribe to presence events on the channels specified in
+//             conf.PresenceSubscriber.Channels if
+//             conf.PresenceSubscriber.Enabled is true (see
+//             loadTest.runPresenceSubscriber).
+//
+// history:    query the history of the channels specified in
+//             conf.History.Channels every conf.History.Interval if
+//             conf.History.Enabled is true (see loadTest.runHistory).
+//
 func (l *loadTest) runUser() {
 	// track each user so we can wait for them all to stop in
 	// loadTest.stop()
 	l.users.Add(1)
 	defer l.users.Done()
+	l.activeUsers.Inc()
+	defer l.activeUsers.Dec()
 
 	// assign a number for this user
 	userNum := l.userCounter.Inc()
@@ -79,16 +107,16 @@ func (l *loadTest) runUser() {
 	defer func() {
 		if err := recover(); err != nil {
 			l.log.Debug("panic occurred", "err", err)
-			l.w.boomer.RecordFailure("ablyboomer", "panic", 0, fmt.Sprintf("panic occurred: %v", err))
+			l.w.recorder.RecordFailure("panic", 0, fmt.Errorf("panic occurred: %v", err))
 		}
 	}()
 
 	// initialise a client, reporting any errors that occur
 	l.log.Debug("initialising client")
-	client, err := l.newClientFunc(ctx, l.w.Conf(), l.log)
+	client, err := l.newClientFunc(withUserNumber(ctx, userNum), l.w.Conf(), l.w.recorder, l.log)
 	if err != nil {
 		l.log.Debug("error initialising client", "err", err)
-		l.w.boomer.RecordFailure("ablyboomer", "client", 0, err.Error())
+		l.w.recorder.RecordFailure("client", 0, err)
 		return
 	}
 	defer client.Close()
@@ -96,19 +124,57 @@ func (l *loadTest) runUser() {
 	// run the enabled tasks until the loadTest is stopped
 	errG, ctx := errgroup.WithContext(ctx)
 	if l.w.Conf().Subscriber.Enabled {
-		errG.Go(func() error { return l.runSubscriber(ctx, client, userNum) })
+		errG.Go(l.trackTask("subscriber", func() error { return l.runSubscriber(ctx, client, userNum) }))
 	}
 	if l.w.Conf().Publisher.Enabled {
-		errG.Go(func() error { return l.runPublisher(ctx, client, userNum) })
+		errG.Go(l.trackTask("publisher", func() error { return l.runPublisher(ctx, client, userNum) }))
 	}
 	if l.w.Conf().Presence.Enabled {
-		errG.Go(func() error { return l.runPresence(ctx, client, userNum) })
+		errG.Go(l.trackTask("presence", func() error { return l.runPresence(ctx, client, userNum) }))
+	}
+	if l.w.Conf().PresenceSubscriber.Enabled {
+		errG.Go(l.trackTask("presenceSubscriber", func() error { return l.runPresenceSubscriber(ctx, client, userNum) }))
+	}
+	if l.w.Conf().History.Enabled {
+		errG.Go(l.trackTask("history", func() error { return l.runHistory(ctx, client, userNum) }))
 	}
 	errG.Wait()
 
 	l.log.Debug("user stopped")
 }
 
+// trackTask wraps the given task function so that it is counted in the load
+// test's active tasks whilst it is running.
+func (l *loadTest) trackTask(name string, task func() error) func() error {
+	return func() error {
+		l.tasksMtx.Lock()
+		if l.activeTasks == nil {
+			l.activeTasks = make(map[string]int64)
+		}
+		l.activeTasks[name]++
+		l.tasksMtx.Unlock()
+
+		defer func() {
+			l.tasksMtx.Lock()
+			l.activeTasks[name]--
+			l.tasksMtx.Unlock()
+		}()
+
+		return task()
+	}
+}
+
+// taskCounts returns a copy of the number of active tasks keyed by task name.
+func (l *loadTest) taskCounts() map[string]int64 {
+	l.tasksMtx.Lock()
+	defer l.tasksMtx.Unlock()
+	counts := make(map[string]int64, len(l.activeTasks))
+	for name, count := range l.activeTasks {
+		counts[name] = count
+	}
+	return counts
+}
+
 func registerPushDevice(
 	ctx context.Context,
 	config *config.Config,
@@ -238,12 +304,66 @@ type pushLogMessage struct {
 	Message  string      `json:"message"`
 }
 
+// errUnexpectedMessage is the error recorded when a subscriber receives a
+// message with a name it didn't subscribe to.
+var errUnexpectedMessage = errors.New("unexpected message name")
+
+// publishQueueSize is the number of batches each publisher task queues per
+// channel whilst waiting for the previous publish to complete, after which
+// scheduling further messages blocks until the queue has space.
+const publishQueueSize = 100
+
+// errPublishBacklog is the error recorded as "publishBacklog" each time a
+// publisher task's queue for a channel is full, meaning the publisher can't
+// sustain the configured publish rate on that channel.
+var errPublishBacklog = errors.New("publish queue full")
+
 // runSubscriber runs a subscriber task which renders the channel names using
 // the given user number and subscribes to each of them.
+//
+// If conf.Subscriber.MessageNames or Filter are set, subscribers only
+// subscribe to messages with those names or which match that subscription
+// filter expression respectively, recording received messages as "matched",
+// or as "unexpected" if they have a name that wasn't subscribed to.
+//
+// If conf.Subscriber.LossGracePeriod is set (and messages aren't being
+// filtered), the sequence numbers of received messages are tracked to detect lost, duplicate and out of order messages
+// (see sequenceTracker).
 func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int64) error {
 	channels := renderChannels(l.subscriberChannels, userNum)
 
 	errG, ctx := errgroup.WithContext(ctx)
+
+	// only subscribe to messages with the configured names or which match
+	// the configured filter expression if set
+	names := make(map[string]bool)
+	for _, name := range splitList(l.w.Conf().Subscriber.MessageNames) {
+		names[name] = true
+	}
+	filter := l.w.Conf().Subscriber.Filter
+	filtering := len(names) > 0 || filter != ""
+	rewind := l.w.Conf().Subscriber.Rewind != ""
+
+	// track sequences unless filtering, since then subscribers only
+	// receive some of the messages published to a channel
+	var sequences *sequenceTracker
+	if grace := l.w.Conf().Subscriber.LossGracePeriod; grace > 0 && !filtering {
+		sequences = newSequenceTracker(l.w.recorder, grace)
+		errG.Go(func() error {
+			ticker := time.NewTicker(grace)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					sequences.expire(now)
+				case <-ctx.Done():
+					// don't expire messages that are in flight when the
+					// load test is stopped
+					return nil
+				}
+			}
+		})
+	}
 	if l.w.Conf().Subscriber.PushDevice.Enabled {
 		l.log.Debug("creating push device")
 
@@ -251,19 +371,19 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 			errG.Go(func() error {
 				for {
 					l.log.Debug("subscribing to metachannel")
-					err := client.Subscribe(ctx, "[meta]log:push", func(message *ably.Message) {
+					err := client.Subscribe(withChannelFamily(ctx, "[meta]log:push"), "[meta]log:push", func(message *ably.Message) {
 						data := message.Data.(string)
 						var msg pushLogMessage
 						if err := json.Unmarshal([]byte(data), &msg); err != nil {
 							l.log.Debug("error parsing message", "err", err)
-							l.w.boomer.RecordFailure("ablyboomer", "pushLog", 0, err.Error())
+							l.w.recorder.RecordFailure("pushLog", 0, err)
 							return
 						}
 						switch msg.Severity {
 						case "warn":
-							l.w.boomer.RecordFailure("ablyboomer", "pushLog", 0, msg.Message)
+							l.w.recorder.RecordFailure("pushLog", 0, errors.New(msg.Message))
 						case "error":
-							l.w.boomer.RecordFailure("ablyboomer", "pushLog", 0, msg.Meta.Error)
+							l.w.recorder.RecordFailure("pushLog", 0, errors.New(msg.Meta.Error))
 						}
 						l.log.Debug("push metachannel:", "message", data)
 					})
@@ -285,7 +405,7 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 		rest, err := ably.NewREST(l.w.conf.Ably.ClientOptions()...)
 		if err != nil {
 			l.log.Debug("error creating a REST client", "err", err)
-			l.w.boomer.RecordFailure("ablyboomer", "createREST", 0, err.Error())
+			l.w.recorder.RecordFailure("createREST", 0, err)
 			return err
 		}
 
@@ -300,11 +420,11 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 			elapsedTime := timeNow() - startTime
 			if err == nil {
 				l.log.Debug("registered push device", "deviceID", deviceID, "elapsedTime", elapsedTime)
-				l.w.boomer.RecordSuccess("ablyboomer", "registerPushDevice", elapsedTime, 0)
+				l.w.recorder.RecordSuccess("registerPushDevice", elapsedTime, 0)
 				break
 			} else {
 				l.log.Debug("error registering push device", "deviceID", deviceID, "elapsedTime", elapsedTime, "err", err)
-				l.w.boomer.RecordFailure("ablyboomer", "registerPushDevice", elapsedTime, err.Error())
+				l.w.recorder.RecordFailure("registerPushDevice", elapsedTime, err)
 				// try again in a second
 				select {
 				case <-time.After(time.Second):
@@ -333,10 +453,10 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 					elapsedTime := timeNow() - startTime
 					if err == nil {
 						l.log.Debug("updated push device", "deviceID", deviceID, "elapsedTime", elapsedTime)
-						l.w.boomer.RecordSuccess("ablyboomer", "updatePushDevice", elapsedTime, 0)
+						l.w.recorder.RecordSuccess("updatePushDevice", elapsedTime, 0)
 					} else {
 						l.log.Debug("error updating push device", "deviceID", deviceID, "elapsedTime", elapsedTime, "err", err)
-						l.w.boomer.RecordFailure("ablyboomer", "updatePushDevice", elapsedTime, err.Error())
+						l.w.recorder.RecordFailure("updatePushDevice", elapsedTime, err)
 					}
 					select {
 					case <-time.After(regUpdateInterval()):
@@ -355,11 +475,11 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 				elapsedTime := timeNow() - startTime
 				if err == nil {
 					l.log.Debug("subscribed push device", "deviceID", deviceID, "elapsedTime", elapsedTime)
-					l.w.boomer.RecordSuccess("ablyboomer", "subscribePushDevice", elapsedTime, 0)
+					l.w.recorder.RecordSuccess("subscribePushDevice", elapsedTime, 0)
 					break
 				} else {
 					l.log.Debug("error subscribing push device", "deviceID", deviceID, "elapsedTime", elapsedTime, "err", err)
-					l.w.boomer.RecordFailure("ablyboomer", "subscribePushDevice", elapsedTime, err.Error())
+					l.w.recorder.RecordFailure("subscribePushDevice", elapsedTime, err)
 					// try again in a second
 					select {
 					case <-time.After(time.Second):
@@ -394,10 +514,10 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 						if err == nil {
 							subscribed = !subscribed
 							l.log.Debug("updated push device subscription", "deviceID", deviceID, "elapsedTime", elapsedTime)
-							l.w.boomer.RecordSuccess("ablyboomer", "updatePushDeviceSubscription", elapsedTime, 0)
+							l.w.recorder.RecordSuccess("updatePushDeviceSubscription", elapsedTime, 0)
 						} else {
 							l.log.Debug("error updating push device subscription", "deviceID", deviceID, "elapsedTime", elapsedTime, "err", err)
-							l.w.boomer.RecordFailure("ablyboomer", "updatePushDeviceSubscription", elapsedTime, err.Error())
+							l.w.recorder.RecordFailure("updatePushDeviceSubscription", elapsedTime, err)
 						}
 						select {
 						case <-time.After(subUpdateInterval()):
@@ -414,32 +534,65 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 		channels = []string{outputChannel}
 	}
 
-	l.log.Debug("starting subscriber", "channels", channels)
+	l.log.Debug("starting subscriber", "channels", channels, "names", l.w.Conf().Subscriber.MessageNames, "filter", filter)
 
+	// subscribe with the family of each channel so that clients can record
+	// stats per channel family
+	families := channelFamilies(l.w.Conf().Subscriber.Channels, channels)
 	for i := range channels {
 		channel := channels[i]
+		ctx := withChannelFamily(ctx, families[i])
 		errG.Go(func() error {
 			for {
 				l.log.Debug("subscribing", "channel", channel)
-				err := client.Subscribe(ctx, channel, func(message *ably.Message) {
-					data := []byte(message.Data.(string))
-					var msg Message
-					if err := json.Unmarshal(data, &msg); err != nil {
+				subscribeStart := timeNow()
+				err := client.Subscribe(ctx, filteredChannel(channel, filter), func(message *ably.Message) {
+					msg, size, err := decodeMessage(message.Data)
+					if err != nil {
 						l.log.Debug("error parsing message", "err", err)
-						l.w.boomer.RecordFailure("ablyboomer", "subscribe", 0, err.Error())
+						l.w.recorder.RecordFailure("subscribe", 0, err)
 						return
 					}
-					latency := timeNow() - msg.Data.Time
-					size := int64(len(data))
-					l.log.Debug("subscriber received message", "channel", channel, "latency", latency, "size", size)
-					l.w.boomer.RecordSuccess("ablyboomer", "subscribe", latency, size)
+					if filtering {
+						if len(names) > 0 && !names[message.Name] {
+							l.log.Debug("subscriber received unexpected message", "channel", channel, "name", message.Name)
+							l.w.recorder.RecordFailure("unexpected", 0, errUnexpectedMessage)
+							return
+						}
+						l.w.recorder.RecordSuccess("matched", 0, size)
+					}
+					// use the timestamp Ably assigned to the message
+					// to determine whether it was published before
+					// subscribing so that only the subscriber's clock
+					// needs to agree with Ably's, falling back to the
+					// publisher's timestamp if the client doesn't
+					// provide it
+					publishedAt := message.Timestamp
+					if publishedAt == 0 {
+						publishedAt = msg.Data.Time
+					}
+					if rewind && publishedAt < subscribeStart {
+						// the message was published before subscribing so
+						// is part of the rewound backlog, record the time
+						// taken to deliver it since subscribing
+						latency := timeNow() - subscribeStart
+						l.log.Debug("subscriber received rewound message", "channel", channel, "latency", latency, "size", size)
+						l.w.recorder.RecordSuccess("rewind", latency, size)
+					} else {
+						latency := timeNow() - msg.Data.Time
+						l.log.Debug("subscriber received message", "channel", channel, "latency", latency, "size", size)
+						l.w.recorder.RecordSuccess("subscribe", latency, size)
+					}
+					if sequences != nil && msg.Data.PublisherID != "" {
+						sequences.track(msg.Data.PublisherID, channel, msg.Data.Seq, msg.Data.Failed, time.Now())
+					}
 				})
 				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 					l.log.Debug("subscriber stopped")
 					return nil
 				} else if err != nil {
 					l.log.Debug("error subscribing", "channel", channel, "err", err)
-					l.w.boomer.RecordFailure("ablyboomer", "subscribe", 0, err.Error())
+					l.w.recorder.RecordFailure("subscribe", 0, err)
 					// try again in a second
 					select {
 					case <-time.After(time.Second):
@@ -454,54 +607,216 @@ func (l *loadTest) runSubscriber(ctx context.Context, client Client, userNum int
 }
 
 // runPublisher runs a publisher task which renders the channel names using the
-// given user number and publishes to each of them at the configured publish
-// interval.
+// given user number and publishes to each of them at the rate configured by
+// conf.Publisher.RateMode (see publishRate), after waiting a random amount of
+// time up to conf.Publisher.StartJitter.
+//
+// Messages are published in batches of up to conf.Publisher.BatchSize
+// messages per call to client.Publish, with each batch published once it's
+// full or once its first message has waited for conf.Publisher.BatchLinger
+// (if BatchLinger is 0, messages scheduled at the same time are published
+// together, e.g. in burst mode).
+//
+// The name and extras of each message are rendered from the templates in
+// conf.Publisher.MessageName, MessageExtras and MessageHeaders (see
+// MessageTemplateData).
+//
+// The publish latency is the time from calling client.Publish until the
+// message is acknowledged (or fails), and is recorded for each message in the
+// batch.
+//
+// Each message includes an ID which is unique to the publisher task and a
+// sequence number which starts at 1 for each channel so that subscribers can
+// detect lost, duplicate and out of order messages, with batches published
+// to each channel serially so that they are sent in sequence order.
 func (l *loadTest) runPublisher(ctx context.Context, client Client, userNum int64) error {
 	channels := renderChannels(l.publisherChannels, userNum)
+	publisherID := fmt.Sprintf("%d-%d-%s", l.w.number, userNum, randomString(8))
 
-	l.log.Debug("starting publisher", "channels", channels, "interval", l.w.Conf().Publisher.PublishInterval)
+	l.log.Debug("starting publisher", "channels", channels, "interval", l.w.Conf().Publisher.PublishInterval, "rateMode", l.w.Conf().Publisher.RateMode)
 
+	// publish with the family of each channel so that clients can record
+	// stats per channel family
+	families := channelFamilies(l.w.Conf().Publisher.Channels, channels)
 	errG, ctx := errgroup.WithContext(ctx)
 	for i := range channels {
 		channel := channels[i]
+		ctx := withChannelFamily(ctx, families[i])
 		errG.Go(func() error {
-			ticker := time.NewTicker(l.w.Conf().Publisher.PublishInterval)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ticker.C:
-					var extras map[string]interface{}
+			batchSize := l.w.Conf().Publisher.BatchSize
+			batchLinger := l.w.Conf().Publisher.BatchLinger
+
+			// publish publishes a batch of messages in a single call
+			// to client.Publish, timestamping them just before they
+			// are published so that subscriber latency doesn't include
+			// the time spent waiting for the batch to fill or in the
+			// publish queue, and returning whether they were published
+			publish := func(batch []*pendingMessage) bool {
+				now := timeNow()
+				messages := make([]*ably.Message, 0, len(batch))
+				sizes := make([]int64, 0, len(batch))
+				var size int64
+				for _, msg := range batch {
+					extras := msg.extras
 					if l.w.Conf().Publisher.PushEnabled {
-						extras = map[string]interface{}{
-							"push": map[string]interface{}{
-								"data": map[string]interface{}{
-									"time": timeNow(),
-								},
+						if extras == nil {
+							extras = make(map[string]interface{})
+						}
+						extras["push"] = map[string]interface{}{
+							"data": map[string]interface{}{
+								"time": now,
 							},
 						}
 					}
-					data, _ := json.Marshal(&Message{
-						Data: Data{
-							Content: randomString(l.w.Conf().Publisher.MessageSize),
-							Time:    timeNow(),
-						},
+					msg.data.Time = now
+					data, n, err := encodeMessage(&Message{Data: msg.data})
+					if err != nil {
+						l.log.Debug("error encoding message", "err", err)
+						l.w.recorder.RecordFailure("publish", 0, err)
+						continue
+					}
+					messages = append(messages, &ably.Message{
+						Name:   msg.name,
+						Data:   data,
+						Extras: extras,
 					})
-					errG.Go(func() error {
-						l.log.Debug("publishing message", "channel", channel, "size", len(data))
-						err := client.Publish(ctx, channel, []*ably.Message{{
-							Data:   data,
-							Extras: extras,
-						}})
-						if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-							l.log.Debug("publication canceled", "channel", channel)
-						} else if err != nil {
-							l.log.Debug("error publishing message", "channel", channel, "err", err)
-							l.w.boomer.RecordFailure("ablyboomer", "publish", 0, err.Error())
-						} else {
-							l.w.boomer.RecordSuccess("ablyboomer", "publish", 0, int64(len(data)))
+					sizes = append(sizes, n)
+					size += n
+				}
+				if len(messages) == 0 {
+					return false
+				}
+				l.log.Debug("publishing messages", "channel", channel, "count", len(messages), "size", size)
+				startTime := timeNow()
+				err := client.Publish(ctx, channel, messages)
+				elapsedTime := timeNow() - startTime
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					l.log.Debug("publication canceled", "channel", channel)
+					return false
+				} else if err != nil {
+					l.log.Debug("error publishing messages", "channel", channel, "elapsedTime", elapsedTime, "err", err)
+					for range messages {
+						l.w.recorder.RecordFailure("publish", elapsedTime, err)
+					}
+					return false
+				}
+				l.log.Debug("published messages", "channel", channel, "elapsedTime", elapsedTime)
+
+				// record each message so that the publish stats count
+				// messages rather than batches
+				for _, n := range sizes {
+					l.w.recorder.RecordSuccess("publish", elapsedTime, n)
+				}
+				return true
+			}
+
+			// publish batches serially so that messages are sent in
+			// sequence order, queueing batches whilst the previous
+			// publish is in flight, and including the number of
+			// messages since the last successful publish in the first
+			// message of each batch so that subscribers don't report
+			// messages which failed to publish as lost
+			queue := make(chan []*pendingMessage, publishQueueSize)
+			errG.Go(func() error {
+				var published int64
+				for {
+					select {
+					case batch := <-queue:
+						batch[0].data.Failed = batch[0].data.Seq - 1 - published
+						if publish(batch) {
+							published = batch[len(batch)-1].data.Seq
 						}
+					case <-ctx.Done():
 						return nil
-					})
+					}
+				}
+			})
+
+			// add messages to a batch which is published once it's full
+			// or once the first message has waited for batchLinger
+			var seq int64
+			var batch []*pendingMessage
+			var lingerC <-chan time.Time
+			flush := func() {
+				if len(batch) > 0 {
+					select {
+					case queue <- batch:
+					default:
+						// the queue is full, so record that the
+						// publish rate isn't being sustained
+						// and wait for space
+						l.log.Debug("publish queue full", "channel", channel)
+						l.w.recorder.RecordFailure("publishBacklog", 0, errPublishBacklog)
+						select {
+						case queue <- batch:
+						case <-ctx.Done():
+						}
+					}
+				}
+				batch = nil
+				lingerC = nil
+			}
+			add := func() {
+				msg := &pendingMessage{data: Data{PublisherID: publisherID, Seq: seq + 1}}
+				if err := l.payload.generate(&msg.data, l.payloadSize.size()); err != nil {
+					l.log.Debug("error generating payload", "err", err)
+					l.w.recorder.RecordFailure("payload", 0, err)
+					return
+				}
+				name, extras, err := l.messageTemplates.render(&MessageTemplateData{
+					UserNumber: userNum,
+					Channel:    channel,
+					Seq:        msg.data.Seq,
+				})
+				if err != nil {
+					l.log.Debug("error rendering message", "err", err)
+					l.w.recorder.RecordFailure("payload", 0, err)
+					return
+				}
+				msg.name = name
+				msg.extras = extras
+
+				// check the message can be encoded before consuming its
+				// sequence number so that subscribers don't report it
+				// as lost
+				if _, _, err := encodeMessage(&Message{Data: msg.data}); err != nil {
+					l.log.Debug("error encoding message", "err", err)
+					l.w.recorder.RecordFailure("payload", 0, err)
+					return
+				}
+				seq++
+				batch = append(batch, msg)
+				if len(batch) >= batchSize {
+					flush()
+				} else if len(batch) == 1 && batchLinger > 0 {
+					lingerC = time.After(batchLinger)
+				}
+			}
+
+			// wait a random amount of time up to the configured jitter so
+			// that users don't all publish at the same time
+			next := time.Now()
+			if jitter := l.w.Conf().Publisher.StartJitter; jitter > 0 {
+				next = next.Add(time.Duration(rand.Int63n(int64(jitter))))
+			}
+
+			// schedule publishes relative to the previous scheduled time
+			// rather than the current time so that the rate doesn't drift
+			delay, count := l.publishRate.next(time.Since(l.started))
+			next = next.Add(delay)
+			for {
+				select {
+				case <-time.After(time.Until(next)):
+					for i := 0; i < count; i++ {
+						add()
+					}
+					if batchLinger <= 0 {
+						flush()
+					}
+					delay, count = l.publishRate.next(time.Since(l.started))
+					next = next.Add(delay)
+				case <-lingerC:
+					flush()
 				case <-ctx.Done():
 					l.log.Debug("publisher stopped", "channel", channel)
 					return nil
@@ -513,56 +828,66 @@ func (l *loadTest) runPublisher(ctx context.Context, client Client, userNum int6
 }
 
 // runPresence runs a presence task which renders the channel names using the
-// given user number and enters each of them.
+// given user number and enters each of them, updating presence and churning
+// as configured (see loadTest.runPresenceChannel).
 func (l *loadTest) runPresence(ctx context.Context, client Client, userNum int64) error {
+	conf := l.w.Conf()
 	channels := renderChannels(l.presenceChannels, userNum)
 
-	l.log.Debug("starting presence", "channels", channels)
+	presence, ok := client.(PresenceClient)
+	if !ok && (l.presenceData != nil || conf.Presence.UpdateInterval > 0 || conf.Presence.ChurnInterval > 0) {
+		err := fmt.Errorf("client %q does not support presence data, updates or churn", conf.Client)
+		l.w.recorder.RecordFailure("presence", 0, err)
+		return err
+	}
+
+	l.log.Debug("starting presence", "channels", channels, "updateInterval", conf.Presence.UpdateInterval, "churnInterval", conf.Presence.ChurnInterval)
 
 	errG, ctx := errgroup.WithContext(ctx)
 	for i := range channels {
 		channel := channels[i]
 		errG.Go(func() error {
-			for {
-				l.log.Debug("entering", "channel", channel)
-				err := client.Enter(ctx, channel, fmt.Sprintf("user%d", userNum))
-				if err == nil {
-					// we successfully entered, just wait for the load test
-					// to stop
-					l.w.boomer.RecordSuccess("ablyboomer", "presence", 0, 0)
-					<-ctx.Done()
-					l.log.Debug("entering done", "channel", channel)
-					return nil
-				} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-					l.log.Debug("presence stopped", "channel", channel)
-					return nil
-				}
-				l.log.Debug("error entering", "channel", channel, "err", err)
-				l.w.boomer.RecordFailure("ablyboomer", "presence", 0, err.Error())
-				// try again in a second
-				select {
-				case <-time.After(time.Second):
-				case <-ctx.Done():
-					return nil
-				}
-			}
+			return l.runPresenceChannel(ctx, client, presence, channel, userNum)
 		})
 	}
 	return errG.Wait()
 }
 
-// stop stops the all the running users for this load test and waits for them
-// to stop.
+// stop stops the all the running users for this load test, waits for them
+// to stop and then stops any profiling that was started for the load test.
 func (l *loadTest) stop() {
 	l.log.Debug("stopping load test")
 	close(l.stopC)
 	l.users.Wait()
+
+	if l.perf != nil {
+		l.log.Debug("stopping perf")
+		if err := l.perf.Stop(); err != nil {
+			l.log.Error("error stopping perf", "err", err)
+		}
+	}
 }
 
-// Data includes content as a string as well as a timestamp.
+// Data includes content as either a string, a JSON document or binary data
+// (see encodeMessage) as well as a timestamp, and the ID of the publisher and
+// the per-channel sequence number of the message, along with the number of
+// messages immediately preceding it which the publisher failed to publish.
 type Data struct {
-	Content string `json:"content"`
-	Time    int64  `json:"time"`
+	Content     string          `json:"content,omitempty"`
+	Doc         json.RawMessage `json:"doc,omitempty"`
+	Binary      []byte          `json:"-"`
+	Time        int64           `json:"time"`
+	PublisherID string          `json:"publisherId,omitempty"`
+	Seq         int64           `json:"seq,omitempty"`
+	Failed      int64           `json:"failed,omitempty"`
+}
+
+// pendingMessage is a message waiting to be published in a batch by a
+// publisher task.
+type pendingMessage struct {
+	name   string
+	extras map[string]interface{}
+	data   Data
 }
 
 // Message is the data that is published by publisher tasks and used by
@@ -0,0 +1,108 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// MessageTemplateData is used to render the name, extras and headers templates
+// of published messages with the number of the current user, the channel the
+// message is being published to, and the per-channel sequence number of the
+// message.
+//
+// The templates have the same functions available as channel templates, so
+// for example to spread messages over 4 names:
+//
+//     event-{{ mod .Seq 4 }}
+//
+type MessageTemplateData struct {
+	UserNumber int64
+	Channel    string
+	Seq        int64
+}
+
+// messageTemplates are the parsed templates used to render the name and
+// extras of published messages, each of which is nil if not configured.
+type messageTemplates struct {
+	name    *template.Template
+	extras  *template.Template
+	headers *template.Template
+}
+
+// newMessageTemplates parses the message name, extras and headers templates
+// from the given config, checking that the extras and headers render JSON
+// objects.
+func newMessageTemplates(conf *config.PublisherConfig) (*messageTemplates, error) {
+	m := &messageTemplates{}
+	for _, t := range []struct {
+		name string
+		text string
+		tmpl **template.Template
+	}{
+		{"name", conf.MessageName, &m.name},
+		{"extras", conf.MessageExtras, &m.extras},
+		{"headers", conf.MessageHeaders, &m.headers},
+	} {
+		if t.text == "" {
+			continue
+		}
+		tmpl, err := template.New(t.name).Funcs(channelFuncs).Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing message %s template %q: %v", t.name, t.text, err)
+		}
+		*t.tmpl = tmpl
+	}
+	if _, _, err := m.render(&MessageTemplateData{UserNumber: 1, Seq: 1}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// render renders the message name and extras using the given data, with the
+// rendered headers being set as the "headers" field of the extras.
+func (m *messageTemplates) render(data *MessageTemplateData) (string, map[string]interface{}, error) {
+	var name string
+	if m.name != nil {
+		var buf bytes.Buffer
+		if err := m.name.Execute(&buf, data); err != nil {
+			return "", nil, fmt.Errorf("error rendering message name: %v", err)
+		}
+		name = buf.String()
+	}
+
+	var extras map[string]interface{}
+	if m.extras != nil {
+		if err := renderJSONObject(m.extras, data, &extras); err != nil {
+			return "", nil, fmt.Errorf("error rendering message extras: %v", err)
+		}
+	}
+	if m.headers != nil {
+		var headers map[string]interface{}
+		if err := renderJSONObject(m.headers, data, &headers); err != nil {
+			return "", nil, fmt.Errorf("error rendering message headers: %v", err)
+		}
+		if extras == nil {
+			extras = make(map[string]interface{})
+		}
+		extras["headers"] = headers
+	}
+
+	return name, extras, nil
+}
+
+// renderJSONObject renders the given template and decodes the output as a
+// JSON object into v.
+func renderJSONObject(tmpl *template.Template, data interface{}, v *map[string]interface{}) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf.Bytes(), v); err != nil {
+		return fmt.Errorf("expected a JSON object, got %q: %v", buf.String(), err)
+	}
+	return nil
+}
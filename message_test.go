@@ -0,0 +1,56 @@
+package ablyboomer
+
+import (
+	"testing"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// TestMessageTemplates tests rendering the name, extras and headers of
+// published messages.
+func TestMessageTemplates(t *testing.T) {
+	templates, err := newMessageTemplates(&config.PublisherConfig{
+		MessageName:    "event-{{ mod .Seq 4 }}",
+		MessageExtras:  `{"ref": {"user": {{ .UserNumber }}}}`,
+		MessageHeaders: `{"channel": "{{ .Channel }}", "seq": "{{ .Seq }}"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, extras, err := templates.render(&MessageTemplateData{UserNumber: 3, Channel: "test", Seq: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "event-2" {
+		t.Fatalf("expected name event-2, got %q", name)
+	}
+	ref, ok := extras["ref"].(map[string]interface{})
+	if !ok || ref["user"] != float64(3) {
+		t.Fatalf("unexpected extras ref: %v", extras["ref"])
+	}
+	headers, ok := extras["headers"].(map[string]interface{})
+	if !ok || headers["channel"] != "test" || headers["seq"] != "6" {
+		t.Fatalf("unexpected extras headers: %v", extras["headers"])
+	}
+
+	// check nothing is rendered if no templates are configured
+	templates, err = newMessageTemplates(&config.PublisherConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, extras, err = templates.render(&MessageTemplateData{UserNumber: 1, Seq: 1})
+	if err != nil || name != "" || extras != nil {
+		t.Fatalf("expected no name or extras, got %q, %v, %v", name, extras, err)
+	}
+
+	// check invalid templates are rejected
+	for _, conf := range []config.PublisherConfig{
+		{MessageName: "{{ .Missing"},
+		{MessageExtras: `{"a": `},
+		{MessageHeaders: `["not", "an", "object"]`},
+	} {
+		if _, err := newMessageTemplates(&conf); err == nil {
+			t.Fatalf("expected an error for %+v", conf)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package ablyboomer
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry is the Prometheus registry that ablyboomer metrics are
+// registered with and which is served by the HTTP /metrics endpoint.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	// metricRequestDuration is a histogram of recorded response times,
+	// which for most task names is a latency (e.g. the delivery latency of
+	// received messages for the "subscribe" name).
+	metricRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "ablyboomer",
+			Name:      "request_duration_seconds",
+			Help:      "Response time of successful requests by task name.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		},
+		[]string{"name"},
+	)
+
+	// metricRequests counts requests by task name and result.
+	metricRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ablyboomer",
+			Name:      "requests_total",
+			Help:      "Number of requests by task name and result (success or failure).",
+		},
+		[]string{"name", "result"},
+	)
+
+	// metricMessageBytes counts the bytes of the messages sent and
+	// received by task name.
+	metricMessageBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ablyboomer",
+			Name:      "message_bytes_total",
+			Help:      "Number of message bytes by task name.",
+		},
+		[]string{"name"},
+	)
+
+	// metricErrors counts failed requests by task name and Ably error
+	// code (or "unknown" for errors that aren't Ably errors).
+	metricErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ablyboomer",
+			Name:      "errors_total",
+			Help:      "Number of errors by task name and Ably error code.",
+		},
+		[]string{"name", "code"},
+	)
+
+	// metricConnectionStateTransitions counts Ably connection state
+	// transitions by the previous and current state.
+	metricConnectionStateTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ablyboomer",
+			Name:      "connection_state_transitions_total",
+			Help:      "Number of Ably connection state transitions by previous and current state.",
+		},
+		[]string{"previous", "current"},
+	)
+
+	// metricConnectionUptime is the percentage of time the worker's Ably
+	// connections have spent CONNECTED (see connectionUptimes).
+	metricConnectionUptime = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "ablyboomer",
+			Name:      "connection_uptime_percent",
+			Help:      "Percentage of time Ably connections have spent CONNECTED.",
+		},
+		func() float64 { return connectionUptimes.percent(time.Now()) },
+	)
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		metricRequestDuration,
+		metricRequests,
+		metricMessageBytes,
+		metricErrors,
+		metricConnectionStateTransitions,
+		metricConnectionUptime,
+	)
+}
+
+// metricsHandler returns an http.Handler that serves the registered metrics
+// in the Prometheus exposition format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// prometheusRecorder is a Recorder that records stats as Prometheus metrics.
+type prometheusRecorder struct{}
+
+// NewPrometheusRecorder returns a Recorder that records stats as Prometheus
+// metrics which are served by the Worker's HTTP /metrics endpoint.
+func NewPrometheusRecorder() Recorder {
+	return prometheusRecorder{}
+}
+
+// RecordSuccess implements the Recorder interface.
+func (prometheusRecorder) RecordSuccess(name string, responseTime, responseLength int64) {
+	metricRequests.WithLabelValues(name, "success").Inc()
+	metricRequestDuration.WithLabelValues(name).Observe(
+		(time.Duration(responseTime) * time.Millisecond).Seconds(),
+	)
+	if responseLength > 0 {
+		metricMessageBytes.WithLabelValues(name).Add(float64(responseLength))
+	}
+}
+
+// RecordFailure implements the Recorder interface.
+func (prometheusRecorder) RecordFailure(name string, responseTime int64, err error) {
+	metricRequests.WithLabelValues(name, "failure").Inc()
+	metricErrors.WithLabelValues(name, errorCode(err)).Inc()
+}
+
+// observeConnectionStateChange records an Ably connection state transition
+// in the Prometheus metrics.
+func observeConnectionStateChange(change ably.ConnectionStateChange) {
+	metricConnectionStateTransitions.WithLabelValues(
+		change.Previous.String(),
+		change.Current.String(),
+	).Inc()
+}
+
+// errorCode returns the Ably error code of the given error as a string, or
+// "unknown" if it isn't an Ably error.
+func errorCode(err error) string {
+	var ptrInfo *ably.ErrorInfo
+	if errors.As(err, &ptrInfo) && ptrInfo != nil {
+		return strconv.Itoa(int(ptrInfo.Code))
+	}
+	var info ably.ErrorInfo
+	if errors.As(err, &info) {
+		return strconv.Itoa(int(info.Code))
+	}
+	return "unknown"
+}
@@ -0,0 +1,40 @@
+package ablyboomer
+
+import (
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestPrometheusRecorder tests recorded stats are exported as Prometheus
+// metrics, checking the change in each metric since the metrics are global.
+func TestPrometheusRecorder(t *testing.T) {
+	requests := metricRequests.WithLabelValues("subscribe", "success")
+	bytes := metricMessageBytes.WithLabelValues("subscribe")
+	errs := metricErrors.WithLabelValues("publish", "42910")
+	beforeRequests := testutil.ToFloat64(requests)
+	beforeBytes := testutil.ToFloat64(bytes)
+	beforeErrs := testutil.ToFloat64(errs)
+
+	recorder := NewPrometheusRecorder()
+	recorder.RecordSuccess("subscribe", 25, 100)
+	recorder.RecordFailure("publish", 0, &ably.ErrorInfo{Code: ably.ErrRateLimitExceededNonfatal})
+
+	if delta := testutil.ToFloat64(requests) - beforeRequests; delta != 1 {
+		t.Fatalf("expected 1 subscribe success, got %v", delta)
+	}
+	if delta := testutil.ToFloat64(bytes) - beforeBytes; delta != 100 {
+		t.Fatalf("expected 100 subscribe bytes, got %v", delta)
+	}
+	if delta := testutil.ToFloat64(errs) - beforeErrs; delta != 1 {
+		t.Fatalf("expected 1 publish error with code 42910, got %v", delta)
+	}
+
+	// check the metrics are registered to be served by the HTTP server
+	if n, err := testutil.GatherAndCount(metricsRegistry, "ablyboomer_requests_total", "ablyboomer_errors_total"); err != nil {
+		t.Fatal(err)
+	} else if n == 0 {
+		t.Fatal("expected metrics to be registered")
+	}
+}
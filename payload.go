@@ -0,0 +1,412 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ably/ably-boomer/config"
+	"github.com/docker/go-units"
+)
+
+// Supported values for conf.Publisher.PayloadType.
+const (
+	PayloadTypeHex    = "hex"
+	PayloadTypeBinary = "binary"
+	PayloadTypeText   = "text"
+	PayloadTypeJSON   = "json"
+	PayloadTypeFiles  = "files"
+)
+
+// Supported values for conf.Publisher.SizeDistribution.
+const (
+	SizeDistributionFixed    = "fixed"
+	SizeDistributionUniform  = "uniform"
+	SizeDistributionNormal   = "normal"
+	SizeDistributionWeighted = "weighted"
+)
+
+// encodeMessage encodes the given message as the data of an Ably message.
+//
+// Messages with binary content are encoded as a []byte containing a 4 byte
+// big-endian length followed by the JSON encoded message and then the binary
+// content, and all other messages are encoded as a JSON string.
+func encodeMessage(msg *Message) (interface{}, int64, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if msg.Data.Binary == nil {
+		return string(data), int64(len(data)), nil
+	}
+	buf := make([]byte, 4, 4+len(data)+len(msg.Data.Binary))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+	buf = append(buf, msg.Data.Binary...)
+	return buf, int64(len(buf)), nil
+}
+
+// decodeMessage decodes the data of an Ably message which was encoded by
+// encodeMessage, returning the message and the size of the data.
+func decodeMessage(data interface{}) (*Message, int64, error) {
+	var b []byte
+	switch d := data.(type) {
+	case string:
+		b = []byte(d)
+	case []byte:
+		b = d
+	default:
+		return nil, 0, fmt.Errorf("unexpected message data type %T", data)
+	}
+	size := int64(len(b))
+
+	var msg Message
+	if len(b) > 0 && b[0] == '{' {
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return nil, 0, err
+		}
+		return &msg, size, nil
+	}
+	if len(b) < 4 {
+		return nil, 0, errors.New("invalid binary message")
+	}
+	n := int64(binary.BigEndian.Uint32(b))
+	if n > size-4 {
+		return nil, 0, errors.New("invalid binary message")
+	}
+	if err := json.Unmarshal(b[4:4+n], &msg); err != nil {
+		return nil, 0, err
+	}
+	msg.Data.Binary = b[4+n:]
+	return &msg, size, nil
+}
+
+// payloadGenerator generates the content of messages published by publisher
+// tasks.
+type payloadGenerator interface {
+	// generate sets the content of the given message data, using the
+	// given size in bytes if the content has a configurable size.
+	generate(data *Data, size int64) error
+}
+
+// newPayloadGenerator returns the payloadGenerator configured by
+// conf.PayloadType.
+func newPayloadGenerator(conf *config.PublisherConfig) (payloadGenerator, error) {
+	switch conf.PayloadType {
+	case "", PayloadTypeHex:
+		return hexPayload{}, nil
+	case PayloadTypeBinary:
+		return binaryPayload{}, nil
+	case PayloadTypeText:
+		return textPayload{}, nil
+	case PayloadTypeJSON:
+		return newJSONPayload(conf.PayloadTemplate)
+	case PayloadTypeFiles:
+		return newFilesPayload(conf.PayloadDir)
+	default:
+		return nil, fmt.Errorf("unknown payload type: %q", conf.PayloadType)
+	}
+}
+
+// hexPayload generates random hex strings, which are incompressible.
+type hexPayload struct{}
+
+func (hexPayload) generate(data *Data, size int64) error {
+	data.Content = randomString(size)
+	return nil
+}
+
+// binaryPayload generates random bytes.
+type binaryPayload struct{}
+
+func (binaryPayload) generate(data *Data, size int64) error {
+	data.Binary = randomBytes(size)
+	return nil
+}
+
+// textPayload generates text made from random words, which is compressible
+// like typical chat messages.
+type textPayload struct{}
+
+func (textPayload) generate(data *Data, size int64) error {
+	data.Content = randomText(size)
+	return nil
+}
+
+// PayloadTemplateData is used to render JSON payload templates with the size
+// of the payload to generate, along with the following functions:
+//
+// randomString: a random hex string of the given length
+//
+// randomText:   random words up to the given length
+//
+// randomInt:    a random integer between the given min and max (inclusive)
+//
+// randomChoice: one of the given arguments at random
+//
+// now:          the current time formatted as RFC3339
+//
+// For example:
+//
+//     {"id": "{{ randomString 16 }}", "score": {{ randomInt 0 100 }}, "text": "{{ randomText .Size }}"}
+//
+type PayloadTemplateData struct {
+	Size int64
+}
+
+// payloadFuncs are the functions available to JSON payload templates.
+var payloadFuncs = template.FuncMap{
+	"randomString": randomString,
+	"randomText":   randomText,
+	"randomInt": func(min, max int64) int64 {
+		return min + rand.Int63n(max-min+1)
+	},
+	"randomChoice": func(choices ...interface{}) interface{} {
+		return choices[rand.Intn(len(choices))]
+	},
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+}
+
+// jsonPayload generates JSON documents by rendering a template.
+type jsonPayload struct {
+	tmpl *template.Template
+}
+
+// newJSONPayload returns a jsonPayload which renders the given template,
+// checking that it renders a valid JSON document.
+func newJSONPayload(text string) (*jsonPayload, error) {
+	if text == "" {
+		return nil, errors.New("no payload template configured")
+	}
+	tmpl, err := template.New("payload").Funcs(payloadFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing payload template: %v", err)
+	}
+	p := &jsonPayload{tmpl: tmpl}
+	if err := p.generate(&Data{}, 16); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *jsonPayload) generate(data *Data, size int64) error {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, PayloadTemplateData{Size: size}); err != nil {
+		return fmt.Errorf("error rendering payload template: %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return fmt.Errorf("payload template rendered invalid JSON: %q", buf.String())
+	}
+	data.Doc = json.RawMessage(buf.Bytes())
+	return nil
+}
+
+// filesPayload uses the contents of files chosen at random, which are sent as
+// JSON documents if they contain valid JSON, as text if they contain valid
+// UTF-8, and as binary otherwise.
+type filesPayload struct {
+	files []Data
+}
+
+// newFilesPayload returns a filesPayload which uses the files in the given
+// directory.
+func newFilesPayload(dir string) (*filesPayload, error) {
+	if dir == "" {
+		return nil, errors.New("no payload directory configured")
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &filesPayload{}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case json.Valid(content):
+			p.files = append(p.files, Data{Doc: json.RawMessage(content)})
+		case utf8.Valid(content):
+			p.files = append(p.files, Data{Content: string(content)})
+		default:
+			p.files = append(p.files, Data{Binary: content})
+		}
+	}
+	if len(p.files) == 0 {
+		return nil, fmt.Errorf("no files found in payload directory %q", dir)
+	}
+	return p, nil
+}
+
+func (p *filesPayload) generate(data *Data, size int64) error {
+	file := p.files[rand.Intn(len(p.files))]
+	data.Content = file.Content
+	data.Doc = file.Doc
+	data.Binary = file.Binary
+	return nil
+}
+
+// sizeDistribution determines the size of generated payloads.
+type sizeDistribution interface {
+	size() int64
+}
+
+// newSizeDistribution returns the sizeDistribution configured by
+// conf.SizeDistribution.
+func newSizeDistribution(conf *config.PublisherConfig) (sizeDistribution, error) {
+	switch conf.SizeDistribution {
+	case "", SizeDistributionFixed:
+		return fixedSize(conf.MessageSize), nil
+	case SizeDistributionUniform:
+		if conf.MessageSizeMin < 0 || conf.MessageSizeMax < conf.MessageSizeMin {
+			return nil, fmt.Errorf("invalid message size range: %d to %d", conf.MessageSizeMin, conf.MessageSizeMax)
+		}
+		return &uniformSize{min: conf.MessageSizeMin, max: conf.MessageSizeMax}, nil
+	case SizeDistributionNormal:
+		if conf.MessageSizeStdDev < 0 {
+			return nil, fmt.Errorf("invalid message size standard deviation: %d", conf.MessageSizeStdDev)
+		}
+		return &normalSize{mean: conf.MessageSize, stdDev: conf.MessageSizeStdDev}, nil
+	case SizeDistributionWeighted:
+		return parseWeightedSize(conf.MessageSizes)
+	default:
+		return nil, fmt.Errorf("unknown size distribution: %q", conf.SizeDistribution)
+	}
+}
+
+// fixedSize is a single size.
+type fixedSize int64
+
+func (f fixedSize) size() int64 {
+	return int64(f)
+}
+
+// uniformSize is a size chosen uniformly between min and max (inclusive).
+type uniformSize struct {
+	min int64
+	max int64
+}
+
+func (u *uniformSize) size() int64 {
+	return u.min + rand.Int63n(u.max-u.min+1)
+}
+
+// normalSize is a normally distributed size, which is never negative.
+type normalSize struct {
+	mean   int64
+	stdDev int64
+}
+
+func (n *normalSize) size() int64 {
+	size := int64(math.Round(rand.NormFloat64()*float64(n.stdDev) + float64(n.mean)))
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// weightedSize is a size chosen from a list of sizes with relative weights.
+type weightedSize struct {
+	sizes       []int64
+	cumWeights  []float64
+	totalWeight float64
+}
+
+// parseWeightedSize parses a comma separated list of sizes and weights like:
+//
+//     100:80, 1KiB:15, 64KiB:5
+//
+// which would generate 100 byte payloads 80% of the time, 1KiB payloads 15% of
+// the time and 64KiB payloads 5% of the time.
+func parseWeightedSize(s string) (*weightedSize, error) {
+	w := &weightedSize{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid message size %q, expected a size and weight like '1KiB:10'", item)
+		}
+		size, err := units.RAMInBytes(strings.TrimSpace(parts[0]))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid message size %q, invalid size", item)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid message size %q, invalid weight", item)
+		}
+		w.totalWeight += weight
+		w.sizes = append(w.sizes, size)
+		w.cumWeights = append(w.cumWeights, w.totalWeight)
+	}
+	if len(w.sizes) == 0 {
+		return nil, errors.New("no message sizes configured")
+	}
+	return w, nil
+}
+
+func (w *weightedSize) size() int64 {
+	r := rand.Float64() * w.totalWeight
+	for i, cum := range w.cumWeights {
+		if r < cum {
+			return w.sizes[i]
+		}
+	}
+	return w.sizes[len(w.sizes)-1]
+}
+
+// randomBytes returns the given number of random bytes.
+func randomBytes(length int64) []byte {
+	data := make([]byte, length)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// textWords are the words used to generate random text.
+var textWords = strings.Fields(`
+	the of and to in is you that it he was for on are as with his they at be
+	this have from or one had by word but not what all were we when your can
+	said there use an each which she do how their if will up other about out
+	many then them these so some her would make like him into time has look
+	two more write go see number no way could people my than first water been
+	call who oil its now find long down day did get come made may part message
+	channel hello thanks meeting today tomorrow great sounds good update ready
+`)
+
+// randomText returns text made from random words which is at most the given
+// length.
+func randomText(length int64) string {
+	var b strings.Builder
+	for {
+		word := textWords[rand.Intn(len(textWords))]
+		if b.Len() > 0 {
+			word = " " + word
+		}
+		if int64(b.Len()+len(word)) > length {
+			break
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
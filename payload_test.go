@@ -0,0 +1,181 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// TestPayloadGenerators tests generating payloads of each type and encoding
+// and decoding them as Ably message data.
+func TestPayloadGenerators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ably-boomer-payloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "doc.json"), []byte(`{"fixture":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		conf     config.PublisherConfig
+		size     int64
+		check    func(*Data) bool
+		dataType string
+	}{
+		{
+			conf:     config.PublisherConfig{PayloadType: PayloadTypeHex},
+			size:     100,
+			check:    func(d *Data) bool { return len(d.Content) == 100 },
+			dataType: "string",
+		},
+		{
+			conf:     config.PublisherConfig{PayloadType: PayloadTypeBinary},
+			size:     100,
+			check:    func(d *Data) bool { return len(d.Binary) == 100 },
+			dataType: "[]byte",
+		},
+		{
+			conf:     config.PublisherConfig{PayloadType: PayloadTypeText},
+			size:     100,
+			check:    func(d *Data) bool { return len(d.Content) > 80 && len(d.Content) <= 100 },
+			dataType: "string",
+		},
+		{
+			conf: config.PublisherConfig{
+				PayloadType:     PayloadTypeJSON,
+				PayloadTemplate: `{"id":"{{ randomString 8 }}","n":{{ randomInt 1 1 }},"size":{{ .Size }}}`,
+			},
+			size: 100,
+			check: func(d *Data) bool {
+				var doc struct {
+					ID   string
+					N    int
+					Size int
+				}
+				return json.Unmarshal(d.Doc, &doc) == nil && len(doc.ID) == 8 && doc.N == 1 && doc.Size == 100
+			},
+			dataType: "string",
+		},
+		{
+			conf:     config.PublisherConfig{PayloadType: PayloadTypeFiles, PayloadDir: dir},
+			check:    func(d *Data) bool { return string(d.Doc) == `{"fixture":true}` },
+			dataType: "string",
+		},
+	} {
+		t.Run(test.conf.PayloadType, func(t *testing.T) {
+			gen, err := newPayloadGenerator(&test.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := Data{Time: 1234, PublisherID: "pub", Seq: 5}
+			if err := gen.generate(&data, test.size); err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(&data) {
+				t.Fatalf("unexpected payload: %+v", data)
+			}
+
+			// check the message round trips
+			encoded, size, err := encodeMessage(&Message{Data: data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch encoded.(type) {
+			case string:
+				if test.dataType != "string" {
+					t.Fatalf("expected %s message data, got string", test.dataType)
+				}
+			case []byte:
+				if test.dataType != "[]byte" {
+					t.Fatalf("expected %s message data, got []byte", test.dataType)
+				}
+			}
+			msg, decodedSize, err := decodeMessage(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decodedSize != size {
+				t.Fatalf("expected decoded size %d, got %d", size, decodedSize)
+			}
+			if msg.Data.Time != 1234 || msg.Data.PublisherID != "pub" || msg.Data.Seq != 5 || !test.check(&msg.Data) {
+				t.Fatalf("unexpected decoded message: %+v", msg.Data)
+			}
+			if !bytes.Equal(msg.Data.Binary, data.Binary) || msg.Data.Content != data.Content {
+				t.Fatalf("expected decoded content to match")
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, conf := range []config.PublisherConfig{
+			{PayloadType: "unknown"},
+			{PayloadType: PayloadTypeJSON},
+			{PayloadType: PayloadTypeJSON, PayloadTemplate: `{"a":`},
+			{PayloadType: PayloadTypeFiles},
+			{PayloadType: PayloadTypeFiles, PayloadDir: filepath.Join(dir, "missing")},
+		} {
+			if _, err := newPayloadGenerator(&conf); err == nil {
+				t.Fatalf("expected an error for %+v", conf)
+			}
+		}
+	})
+}
+
+// TestSizeDistributions tests generating payload sizes from each size
+// distribution.
+func TestSizeDistributions(t *testing.T) {
+	for _, test := range []struct {
+		conf    config.PublisherConfig
+		allowed func(int64) bool
+	}{
+		{
+			conf:    config.PublisherConfig{SizeDistribution: SizeDistributionFixed, MessageSize: 10},
+			allowed: func(size int64) bool { return size == 10 },
+		},
+		{
+			conf:    config.PublisherConfig{SizeDistribution: SizeDistributionUniform, MessageSizeMin: 10, MessageSizeMax: 20},
+			allowed: func(size int64) bool { return size >= 10 && size <= 20 },
+		},
+		{
+			conf:    config.PublisherConfig{SizeDistribution: SizeDistributionNormal, MessageSize: 10, MessageSizeStdDev: 100},
+			allowed: func(size int64) bool { return size >= 0 },
+		},
+		{
+			conf:    config.PublisherConfig{SizeDistribution: SizeDistributionWeighted, MessageSizes: "100:1, 1KiB:1"},
+			allowed: func(size int64) bool { return size == 100 || size == 1024 },
+		},
+	} {
+		t.Run(test.conf.SizeDistribution, func(t *testing.T) {
+			dist, err := newSizeDistribution(&test.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 1000; i++ {
+				if size := dist.size(); !test.allowed(size) {
+					t.Fatalf("unexpected size %d", size)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, conf := range []config.PublisherConfig{
+			{SizeDistribution: "unknown"},
+			{SizeDistribution: SizeDistributionUniform, MessageSizeMin: 20, MessageSizeMax: 10},
+			{SizeDistribution: SizeDistributionWeighted},
+			{SizeDistribution: SizeDistributionWeighted, MessageSizes: "100"},
+			{SizeDistribution: SizeDistributionWeighted, MessageSizes: "100:0"},
+		} {
+			if _, err := newSizeDistribution(&conf); err == nil {
+				t.Fatalf("expected an error for %+v", conf)
+			}
+		}
+	})
+}
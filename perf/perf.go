@@ -5,7 +5,12 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,6 +20,17 @@ import (
 
 const defaultKeyPrefix = "perf"
 
+const (
+	// mutexProfileFraction is the rate at which mutex contention events
+	// are reported when the mutex profile is enabled (see
+	// runtime.SetMutexProfileFraction).
+	mutexProfileFraction = 100
+
+	// blockProfileRate is the rate at which blocking events are sampled
+	// when the block profile is enabled (see runtime.SetBlockProfileRate).
+	blockProfileRate = int(10 * time.Microsecond)
+)
+
 // S3ObjectPutter provides a PutObject function for writing to S3.
 type S3ObjectPutter interface {
 	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
@@ -22,8 +38,54 @@ type S3ObjectPutter interface {
 
 // Conf represents Perf's configuration.
 type Conf struct {
-	CPUProfileDir string
-	S3Bucket      string
+	CPUProfileDir    string
+	S3Bucket         string
+	S3Endpoint       string
+	S3Region         string
+	S3ForcePathStyle bool
+	Sink             string
+	SinkDir          string
+	SinkURL          string
+	KeyPrefix        string
+	HeapProfile      bool
+	AllocsProfile    bool
+	GoroutineProfile bool
+	MutexProfile     bool
+	BlockProfile     bool
+	SnapshotInterval time.Duration
+}
+
+// profileNames returns the names of the enabled runtime/pprof profiles other
+// than the CPU profile.
+func (c *Conf) profileNames() []string {
+	var names []string
+	if c.HeapProfile {
+		names = append(names, "heap")
+	}
+	if c.AllocsProfile {
+		names = append(names, "allocs")
+	}
+	if c.GoroutineProfile {
+		names = append(names, "goroutine")
+	}
+	if c.MutexProfile {
+		names = append(names, "mutex")
+	}
+	if c.BlockProfile {
+		names = append(names, "block")
+	}
+	return names
+}
+
+// KeyData is the data available to the Conf.KeyPrefix template when
+// rendering the key prefix for uploaded profiles, for example:
+//
+//     perf/{{ .Hostname }}/{{ .TestID }}/worker-{{ .WorkerNumber }}
+//
+type KeyData struct {
+	Hostname     string
+	WorkerNumber int64
+	TestID       string
 }
 
 // Perf provides profiling and performance debugging instrumentation.
@@ -31,8 +93,22 @@ type Perf struct {
 	started   bool
 	conf      Conf
 	s3Client  S3ObjectPutter
+	sink      ArtifactSink
 	pprofFile *os.File
 	fileName  string
+
+	// keyData and keyPrefix are used to generate keys for uploads.
+	keyData   KeyData
+	keyPrefix *template.Template
+
+	// hostname and label are used to name profile files.
+	hostname string
+	label    string
+
+	snapshotMtx  sync.Mutex
+	snapshotErr  error
+	snapshotStop chan struct{}
+	snapshotDone chan struct{}
 }
 
 // Only allow alphanumeric chars, - _ and . in file names.
@@ -54,9 +130,37 @@ func NewWithS3(conf Conf, s3Client S3ObjectPutter) *Perf {
 	}
 }
 
+// NewWithSink creates a new instance of a Perf with defaults and a supplied
+// ArtifactSink which profiles are uploaded to rather than the sink
+// configured by Conf.Sink.
+func NewWithSink(conf Conf, sink ArtifactSink) *Perf {
+	return &Perf{
+		conf: conf,
+		sink: sink,
+	}
+}
+
 // Start begins profiling based on the environment configuration. Start should
 // be called at most once. If start is called, stop must be called.
 func (p *Perf) Start() error {
+	return p.StartWithLabel(strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// StartForWorker is like Start but labels the profiles with the given worker
+// number and test ID, which are also available to the key prefix template.
+func (p *Perf) StartForWorker(workerNumber int64, testID string) error {
+	p.keyData.WorkerNumber = workerNumber
+	p.keyData.TestID = testID
+	return p.StartWithLabel(fmt.Sprintf("worker%d-%s", workerNumber, testID))
+}
+
+// StartWithLabel is like Start but names the profile using the given label
+// rather than the current time, so that profiles can be correlated with
+// whatever the label identifies (e.g. a particular worker and load test).
+//
+// As well as the CPU profile, any other enabled profiles are written when
+// Stop is called, and also every SnapshotInterval if it is set.
+func (p *Perf) StartWithLabel(label string) error {
 	if p.started {
 		return fmt.Errorf("perf is already started")
 	}
@@ -69,29 +173,133 @@ func (p *Perf) Start() error {
 	if err != nil {
 		hostname = "unknown"
 	}
-	baseName := replaceChars.ReplaceAllString(
-		fmt.Sprintf(
-			"cpuprofile-%s-%d.pprof",
-			hostname,
-			time.Now().Unix(),
-		),
-		"_",
-	)
-	p.fileName = path.Join(p.conf.CPUProfileDir, baseName)
+	p.hostname = hostname
+	p.label = label
+	p.keyData.Hostname = hostname
+	if p.keyData.TestID == "" {
+		p.keyData.TestID = label
+	}
+
+	keyPrefix := p.conf.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = defaultKeyPrefix
+	}
+	p.keyPrefix, err = template.New("keyPrefix").Parse(keyPrefix)
+	if err != nil {
+		return fmt.Errorf("error parsing key prefix %q: %s", keyPrefix, err)
+	}
+	if _, err := p.configuredSink(); err != nil {
+		return err
+	}
+
+	p.fileName = p.profileFileName("cpuprofile", "")
 	f, err := os.Create(p.fileName)
 	if err != nil {
 		return err
 	}
 
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("error starting cpu profile: %s", err)
+	}
 	p.pprofFile = f
 	p.started = true
-	pprof.StartCPUProfile(f)
+
+	if p.conf.MutexProfile {
+		runtime.SetMutexProfileFraction(mutexProfileFraction)
+	}
+	if p.conf.BlockProfile {
+		runtime.SetBlockProfileRate(blockProfileRate)
+	}
+
+	if p.conf.SnapshotInterval > 0 && len(p.conf.profileNames()) > 0 {
+		p.snapshotStop = make(chan struct{})
+		p.snapshotDone = make(chan struct{})
+		go p.runSnapshots()
+	}
 
 	return nil
 }
 
+// profileFileName returns the path of the file to write the given kind of
+// profile to, with an optional suffix appended to the name (e.g. a
+// timestamp for periodic snapshots).
+func (p *Perf) profileFileName(kind, suffix string) string {
+	name := fmt.Sprintf("%s-%s-%s", kind, p.hostname, p.label)
+	if suffix != "" {
+		name += "-" + suffix
+	}
+	baseName := replaceChars.ReplaceAllString(name+".pprof", "_")
+	return path.Join(p.conf.CPUProfileDir, baseName)
+}
+
+// runSnapshots writes timestamped snapshots of the enabled profiles every
+// SnapshotInterval until Stop is called.
+func (p *Perf) runSnapshots() {
+	defer close(p.snapshotDone)
+	ticker := time.NewTicker(p.conf.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			err := p.writeProfiles(strconv.FormatInt(t.Unix(), 10))
+			if err != nil {
+				p.snapshotMtx.Lock()
+				if p.snapshotErr == nil {
+					p.snapshotErr = err
+				}
+				p.snapshotMtx.Unlock()
+			}
+		case <-p.snapshotStop:
+			return
+		}
+	}
+}
+
+// writeProfiles writes each of the enabled profiles to a file using the
+// given file name suffix, and uploads them to S3 if configured.
+func (p *Perf) writeProfiles(suffix string) error {
+	for _, name := range p.conf.profileNames() {
+		fileName := p.profileFileName(name, suffix)
+		if err := p.writeProfile(name, fileName); err != nil {
+			return err
+		}
+		if err := p.upload(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProfile writes the named runtime/pprof profile to the given file.
+func (p *Perf) writeProfile(name, fileName string) error {
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+
+	// Run a GC so the heap profile reflects the current live heap.
+	if name == "heap" {
+		runtime.GC()
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := profile.WriteTo(f, 0); err != nil {
+		return fmt.Errorf("error writing %s profile: %s", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing %s profile file: %s", name, err)
+	}
+	return nil
+}
+
 // Stop will stop any profiling that was started and write the files to the
-// configured locations (disk and s3). Stop may be called multiple times so
+// configured locations (disk and the configured sink). Stop may be called multiple times so
 // it is safe to both call stop directly and defer calls to stop.
 func (p *Perf) Stop() error {
 	if !p.started {
@@ -100,31 +308,64 @@ func (p *Perf) Stop() error {
 	p.started = false
 	defer p.pprofFile.Close()
 
+	// Stop the CPU profile first so that it doesn't include the work of
+	// writing the other profiles (e.g. the GC before the heap profile).
 	pprof.StopCPUProfile()
-	err := p.pprofFile.Sync()
-	if err != nil {
-		return fmt.Errorf("error syncing pprof file: %s", err)
+	var cpuErr error
+	if err := p.pprofFile.Sync(); err != nil {
+		cpuErr = fmt.Errorf("error syncing pprof file: %s", err)
+	} else if err := p.pprofFile.Close(); err != nil {
+		cpuErr = fmt.Errorf("error closing pprof file: %s", err)
 	}
 
-	err = p.pprofFile.Close()
-	if err != nil {
-		return fmt.Errorf("error closing pprof file: %s", err)
+	if p.snapshotStop != nil {
+		close(p.snapshotStop)
+		<-p.snapshotDone
+		p.snapshotStop = nil
 	}
 
-	if p.conf.S3Bucket != "" {
-		return p.uploadToS3(p.pprofFile.Name())
+	// Write the final snapshot of the other profiles before disabling
+	// mutex and block profiling.
+	profilesErr := p.writeProfiles("")
+	if p.conf.MutexProfile {
+		runtime.SetMutexProfileFraction(0)
+	}
+	if p.conf.BlockProfile {
+		runtime.SetBlockProfileRate(0)
 	}
 
-	return nil
+	if cpuErr != nil {
+		return cpuErr
+	}
+	if err := p.upload(p.pprofFile.Name()); err != nil {
+		return err
+	}
+
+	if profilesErr != nil {
+		return profilesErr
+	}
+	return p.snapshotErr
 }
 
-// Returns either the configured s3 client or the default s3 client if unset.
+// Returns either the configured s3 client or the default s3 client if unset,
+// using a custom endpoint if configured so S3-compatible services like MinIO
+// can be used.
 func (p *Perf) configuredS3Client() (S3ObjectPutter, error) {
 	if p.s3Client != nil {
 		return p.s3Client, nil
 	}
 
-	sess, err := session.NewSession()
+	cfg := aws.NewConfig()
+	if p.conf.S3Endpoint != "" {
+		cfg = cfg.WithEndpoint(p.conf.S3Endpoint)
+	}
+	if p.conf.S3Region != "" {
+		cfg = cfg.WithRegion(p.conf.S3Region)
+	}
+	if p.conf.S3ForcePathStyle {
+		cfg = cfg.WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSession(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -132,40 +373,68 @@ func (p *Perf) configuredS3Client() (S3ObjectPutter, error) {
 	return s3.New(sess), nil
 }
 
-// Uploads a file to the S3 perf bucket.
-func (p *Perf) uploadToS3(fileName string) error {
-	s3Client, err := p.configuredS3Client()
-	if err != nil {
-		return err
+// Returns either the supplied sink or the sink selected by Conf.Sink, which
+// defaults to S3 if an S3 bucket is configured. A nil sink means profiles
+// are only written to disk.
+func (p *Perf) configuredSink() (ArtifactSink, error) {
+	if p.sink != nil {
+		return p.sink, nil
 	}
 
-	file, err := os.Open(fileName)
-	if err != nil {
-		return err
+	switch p.conf.Sink {
+	case "":
+		if p.conf.S3Bucket == "" {
+			return nil, nil
+		}
+	case SinkS3:
+		if p.conf.S3Bucket == "" {
+			return nil, fmt.Errorf("the s3 sink requires an s3 bucket")
+		}
+	case SinkLocal:
+		if p.conf.SinkDir == "" {
+			return nil, fmt.Errorf("the local sink requires a sink dir")
+		}
+		p.sink = NewLocalSink(p.conf.SinkDir)
+		return p.sink, nil
+	case SinkHTTP:
+		if p.conf.SinkURL == "" {
+			return nil, fmt.Errorf("the http sink requires a sink url")
+		}
+		p.sink = NewHTTPSink(nil, p.conf.SinkURL)
+		return p.sink, nil
+	default:
+		return nil, fmt.Errorf("unknown perf sink: %q", p.conf.Sink)
 	}
-	defer file.Close()
 
-	key := path.Join(defaultKeyPrefix, path.Base(fileName))
-
-	// Get file size and read the file content into a buffer.
-	fileInfo, err := file.Stat()
+	s3Client, err := p.configuredS3Client()
 	if err != nil {
-		return fmt.Errorf("error reading file stat: %s", err)
+		return nil, err
 	}
-	size := fileInfo.Size()
+	p.sink = NewS3Sink(s3Client, p.conf.S3Bucket)
+	return p.sink, nil
+}
 
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
-		Bucket:        aws.String(p.conf.S3Bucket),
-		Key:           aws.String(key),
-		ACL:           aws.String("private"),
-		Body:          file,
-		ContentLength: aws.Int64(size),
-		ContentType:   aws.String("application/octet-stream"),
-	})
+// Returns the key to upload the given file with, which is the base name of
+// the file prefixed with the rendered key prefix template.
+func (p *Perf) uploadKey(fileName string) (string, error) {
+	var prefix strings.Builder
+	if err := p.keyPrefix.Execute(&prefix, &p.keyData); err != nil {
+		return "", fmt.Errorf("error rendering key prefix: %s", err)
+	}
+	return path.Join(prefix.String(), path.Base(fileName)), nil
+}
 
+// Uploads a file to the configured sink, if any.
+func (p *Perf) upload(fileName string) error {
+	sink, err := p.configuredSink()
+	if err != nil || sink == nil {
+		return err
+	}
+
+	key, err := p.uploadKey(fileName)
 	if err != nil {
-		return fmt.Errorf("s3 PutObject returned error: %s", err)
+		return err
 	}
 
-	return nil
+	return sink.Put(key, fileName)
 }
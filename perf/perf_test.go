@@ -1,8 +1,11 @@
 package perf
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -190,6 +193,104 @@ func TestNewPerf(t *testing.T) {
 		}
 	})
 
+	t.Run("perf names profiles using the given label", func(ts *testing.T) {
+		perf := New(Conf{
+			CPUProfileDir: os.TempDir(),
+		})
+		err := perf.StartWithLabel("worker3-1600000000")
+		if err != nil {
+			ts.Fatalf("error starting perf: %s", err)
+		}
+		defer func() {
+			err := perf.Stop()
+			// Cleanup the pprof file if we can.
+			if path.Ext(perf.fileName) == ".pprof" {
+				os.Remove(perf.fileName)
+			}
+			if err != nil {
+				ts.Fatalf("error stopping perf: %s", err)
+			}
+		}()
+
+		// Test that the label is the suffix of the pprof file name.
+		expectedSuffix := "-worker3-1600000000.pprof"
+		if !strings.HasSuffix(perf.fileName, expectedSuffix) {
+			ts.Fatalf(
+				"unexpected pprof file name, got: %s, wanted suffix: %s",
+				perf.fileName,
+				expectedSuffix,
+			)
+		}
+	})
+
+	t.Run("perf writes other profiles and periodic snapshots", func(ts *testing.T) {
+		profileDir, err := ioutil.TempDir("", "perf-profiles")
+		if err != nil {
+			ts.Fatal(err)
+		}
+		defer os.RemoveAll(profileDir)
+		sinkDir, err := ioutil.TempDir("", "perf-sink")
+		if err != nil {
+			ts.Fatal(err)
+		}
+		defer os.RemoveAll(sinkDir)
+		sink := &mockSink{sink: NewLocalSink(sinkDir)}
+
+		perf := NewWithSink(Conf{
+			CPUProfileDir:    profileDir,
+			HeapProfile:      true,
+			GoroutineProfile: true,
+			SnapshotInterval: 50 * time.Millisecond,
+		}, sink)
+		err = perf.Start()
+		if err != nil {
+			ts.Fatalf("error starting perf: %s", err)
+		}
+
+		time.Sleep(180 * time.Millisecond)
+
+		err = perf.Stop()
+		if err != nil {
+			ts.Fatalf("error stopping perf: %s", err)
+		}
+
+		// Test that the sink contains at least one snapshot plus a final
+		// profile for each of the heap and goroutine profiles, along with
+		// the cpu profile.
+		counts := make(map[string]int)
+		for _, key := range sink.keys {
+			base := path.Base(key)
+			counts[base[:strings.Index(base, "-")]]++
+			fileName := filepath.Join(sinkDir, filepath.FromSlash(key))
+			if stat, err := os.Stat(fileName); err != nil {
+				ts.Fatalf("profile file missing from sink: %s", err)
+			} else if stat.Size() == 0 {
+				ts.Fatalf("profile file is empty: %s", fileName)
+			}
+		}
+		for _, name := range []string{"heap", "goroutine"} {
+			if counts[name] < 2 {
+				ts.Errorf(
+					"unexpected number of %s profiles, got: %d, wanted at least: 2",
+					name,
+					counts[name],
+				)
+			}
+		}
+		if counts["cpuprofile"] != 1 {
+			ts.Errorf("unexpected number of cpu profiles, got: %d, wanted: 1", counts["cpuprofile"])
+		}
+		if len(counts) != 3 {
+			ts.Errorf("unexpected profiles written: %v", counts)
+		}
+
+		// Test that the cpu profile was uploaded last.
+		expectedKey := path.Join(defaultKeyPrefix, path.Base(perf.fileName))
+		if len(sink.keys) == 0 || sink.keys[len(sink.keys)-1] != expectedKey {
+			ts.Errorf("expected cpu profile to be uploaded last")
+		}
+	})
+
 	t.Run("perf doesn't run by default", func(ts *testing.T) {
 		// Check that the environment doesn't contain perf configuration.
 		profileDir, set := os.LookupEnv("PERF_CPU_PROFILE_DIR")
@@ -258,9 +359,22 @@ func TestNewPerf(t *testing.T) {
 	})
 }
 
+// mockSink is an ArtifactSink which records the keys it is given before
+// passing them to the underlying sink.
+type mockSink struct {
+	sink ArtifactSink
+	keys []string
+}
+
+func (s *mockSink) Put(key, fileName string) error {
+	s.keys = append(s.keys, key)
+	return s.sink.Put(key, fileName)
+}
+
 type mockS3 struct {
 	err    error
 	input  *s3.PutObjectInput
+	keys   []string
 	output *s3.PutObjectOutput
 }
 
@@ -268,6 +382,9 @@ func (s *mockS3) PutObject(
 	input *s3.PutObjectInput,
 ) (*s3.PutObjectOutput, error) {
 	s.input = input
+	if input.Key != nil {
+		s.keys = append(s.keys, *input.Key)
+	}
 	if s.err != nil {
 		return nil, s.err
 	}
@@ -0,0 +1,155 @@
+package perf
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Supported values for Conf.Sink.
+const (
+	SinkLocal = "local"
+	SinkS3    = "s3"
+	SinkHTTP  = "http"
+)
+
+// ArtifactSink stores the profile files written by Perf.
+type ArtifactSink interface {
+	// Put stores the contents of the given local file using the given
+	// key, which is a slash separated path.
+	Put(key, fileName string) error
+}
+
+// localSink is an ArtifactSink that copies files into a local directory.
+type localSink struct {
+	dir string
+}
+
+// NewLocalSink returns an ArtifactSink that copies files into the given
+// directory, creating any parent directories of the key as needed.
+func NewLocalSink(dir string) ArtifactSink {
+	return &localSink{dir: dir}
+}
+
+// Put copies the given file to dir/key.
+func (l *localSink) Put(key, fileName string) error {
+	dst := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("error creating local sink directory: %s", err)
+	}
+
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, src); err != nil {
+		return fmt.Errorf("error copying file to local sink: %s", err)
+	}
+	return f.Close()
+}
+
+// s3Sink is an ArtifactSink that uploads files to an S3 (or S3-compatible)
+// bucket.
+type s3Sink struct {
+	client S3ObjectPutter
+	bucket string
+}
+
+// NewS3Sink returns an ArtifactSink that uploads files to the given bucket
+// using the given S3 client.
+func NewS3Sink(client S3ObjectPutter, bucket string) ArtifactSink {
+	return &s3Sink{client: client, bucket: bucket}
+}
+
+// Put uploads the given file to the S3 bucket using the given key.
+func (s *s3Sink) Put(key, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Get file size and read the file content into a buffer.
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading file stat: %s", err)
+	}
+	size := fileInfo.Size()
+
+	_, err = s.client.PutObject(&s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		ACL:           aws.String("private"),
+		Body:          file,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String("application/octet-stream"),
+	})
+
+	if err != nil {
+		return fmt.Errorf("s3 PutObject returned error: %s", err)
+	}
+
+	return nil
+}
+
+// httpSink is an ArtifactSink that uploads files using HTTP PUT requests.
+type httpSink struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewHTTPSink returns an ArtifactSink that uploads files by sending a PUT
+// request to the given base URL with the key appended to the path.
+func NewHTTPSink(client *http.Client, baseURL string) ArtifactSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSink{client: client, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Put uploads the given file to baseURL/key.
+func (h *httpSink) Put(key, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading file stat: %s", err)
+	}
+
+	u := h.baseURL + "/" + path.Clean(key)
+	req, err := http.NewRequest("PUT", u, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fileInfo.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http PUT returned error: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("http PUT returned unexpected status: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package perf
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestSinks(t *testing.T) {
+	t.Run("local sink copies profiles using the key prefix", func(ts *testing.T) {
+		sinkDir, err := ioutil.TempDir("", "perf-sink")
+		if err != nil {
+			ts.Fatalf("error creating sink dir: %s", err)
+		}
+		defer os.RemoveAll(sinkDir)
+
+		perf := New(Conf{
+			CPUProfileDir: os.TempDir(),
+			Sink:          SinkLocal,
+			SinkDir:       sinkDir,
+			KeyPrefix:     "profiles/{{ .TestID }}/worker-{{ .WorkerNumber }}",
+		})
+		err = perf.StartForWorker(3, "1600000000")
+		if err != nil {
+			ts.Fatalf("error starting perf: %s", err)
+		}
+		defer os.Remove(perf.fileName)
+
+		err = perf.Stop()
+		if err != nil {
+			ts.Fatalf("error stopping perf: %s", err)
+		}
+
+		// Test that the cpu profile was copied to the sink dir.
+		expectedPath := filepath.Join(
+			sinkDir,
+			"profiles",
+			"1600000000",
+			"worker-3",
+			path.Base(perf.fileName),
+		)
+		if _, err := os.Stat(expectedPath); err != nil {
+			ts.Fatalf("profile missing from local sink: %s", err)
+		}
+	})
+
+	t.Run("http sink puts profiles to the sink url", func(ts *testing.T) {
+		var method, reqPath, contentType string
+		var body []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			reqPath = r.URL.Path
+			contentType = r.Header.Get("Content-Type")
+			body, _ = ioutil.ReadAll(r.Body)
+		}))
+		defer srv.Close()
+
+		perf := New(Conf{
+			CPUProfileDir: os.TempDir(),
+			Sink:          SinkHTTP,
+			SinkURL:       srv.URL + "/uploads/",
+		})
+		err := perf.Start()
+		if err != nil {
+			ts.Fatalf("error starting perf: %s", err)
+		}
+		defer os.Remove(perf.fileName)
+
+		err = perf.Stop()
+		if err != nil {
+			ts.Fatalf("error stopping perf: %s", err)
+		}
+
+		if method != "PUT" {
+			ts.Errorf("unexpected http method, got: %s, wanted: PUT", method)
+		}
+		expectedPath := path.Join("/uploads", defaultKeyPrefix, path.Base(perf.fileName))
+		if reqPath != expectedPath {
+			ts.Errorf(
+				"unexpected http path, got: %s, wanted: %s",
+				reqPath,
+				expectedPath,
+			)
+		}
+		if contentType != "application/octet-stream" {
+			ts.Errorf("unexpected content type: %s", contentType)
+		}
+		if len(body) == 0 {
+			ts.Errorf("expected a non-empty http body")
+		}
+	})
+
+	t.Run("http sink returns an error for unexpected statuses", func(ts *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer srv.Close()
+
+		f, err := ioutil.TempFile("", "perf-sink")
+		if err != nil {
+			ts.Fatalf("error creating temp file: %s", err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+
+		err = NewHTTPSink(nil, srv.URL).Put("perf/test.pprof", f.Name())
+		if err == nil {
+			ts.Fatalf("expected an error from the http sink")
+		}
+	})
+
+	t.Run("perf rejects an unknown sink", func(ts *testing.T) {
+		perf := New(Conf{
+			CPUProfileDir: os.TempDir(),
+			Sink:          "unknown",
+		})
+		if err := perf.Start(); err == nil {
+			perf.Stop()
+			os.Remove(perf.fileName)
+			ts.Fatalf("expected an error starting perf with an unknown sink")
+		}
+	})
+}
@@ -0,0 +1,449 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-go/ably"
+	"golang.org/x/sync/errgroup"
+)
+
+// PresenceClient is an optional interface implemented by Clients which can
+// enter presence with data, update presence data and leave presence, and is
+// required to run presence tasks with data templates, updates or churn.
+type PresenceClient interface {
+	// EnterWithData enters the given channel using the given clientID and
+	// data.
+	EnterWithData(ctx context.Context, channel, clientID string, data interface{}) error
+
+	// UpdateWithData updates the presence data of the given clientID in the
+	// given channel.
+	UpdateWithData(ctx context.Context, channel, clientID string, data interface{}) error
+
+	// LeaveWithData leaves the given channel using the given clientID and
+	// data.
+	LeaveWithData(ctx context.Context, channel, clientID string, data interface{}) error
+}
+
+// PresenceTemplateData is used to render the presence data template with the
+// number and clientID of the current user and the channel being entered.
+//
+// The template has the same functions available as channel templates, so for
+// example to spread users over 4 rooms:
+//
+//     {"room": {{ mod .UserNumber 4 }}}
+//
+type PresenceTemplateData struct {
+	UserNumber int64
+	ClientID   string
+	Channel    string
+}
+
+// presenceClientID returns the default clientID users enter presence with
+// when conf.Ably.ClientID isn't set.
+func presenceClientID(userNum int64) string {
+	return fmt.Sprintf("user%d", userNum)
+}
+
+// clientID returns the clientID of the given user, which is rendered from
+// conf.Ably.ClientID if set, and otherwise defaults to "user<N>".
+func (l *loadTest) clientID(userNum int64) string {
+	if l.auth != nil {
+		if clientID, err := l.auth.renderClientID(userNum); err == nil && clientID != "" {
+			return clientID
+		}
+	}
+	return presenceClientID(userNum)
+}
+
+// parsePresenceData parses the presence data template from the given config,
+// returning nil if not configured.
+func parsePresenceData(conf *config.PresenceConfig) (*template.Template, error) {
+	if conf.Data == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("data").Funcs(channelFuncs).Parse(conf.Data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing presence data template %q: %v", conf.Data, err)
+	}
+	if _, err := renderPresenceData(tmpl, &PresenceTemplateData{UserNumber: 1, ClientID: presenceClientID(1)}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderPresenceData renders the presence data to send with the given data,
+// which is the JSON encoded message Data with the rendered template as the
+// content and the current time so that presence subscribers can measure the
+// latency of presence events.
+func renderPresenceData(tmpl *template.Template, data *PresenceTemplateData) (string, error) {
+	var content string
+	if tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("error rendering presence data: %v", err)
+		}
+		content = buf.String()
+	}
+	encoded, err := json.Marshal(&Data{Content: content, Time: timeNow()})
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// runPresenceChannel enters the given channel, retrying every second until it
+// succeeds, and then updates the presence data every conf.UpdateInterval and
+// leaves and re-enters the channel every conf.ChurnInterval (if set) until
+// the given context is done.
+//
+// The time taken for each operation to be acknowledged is recorded as
+// "presence", "presenceUpdate" and "presenceLeave" respectively.
+func (l *loadTest) runPresenceChannel(ctx context.Context, client Client, presence PresenceClient, channel string, userNum int64) error {
+	conf := l.w.Conf().Presence
+	clientID := l.clientID(userNum)
+
+	// track whether the member is expected to be present so presence
+	// subscribers can check presence sets, no longer expecting it once
+	// the user stops (since it may still be present until its connection
+	// is closed)
+	l.presenceMembers.set(channel, clientID, false)
+	defer l.presenceMembers.set(channel, clientID, false)
+
+	// do renders the presence data and runs the given operation with it,
+	// recording the time taken for it to be acknowledged as the given stat
+	do := func(name string, op func(data interface{}) error) error {
+		var data interface{} = ""
+		if presence != nil {
+			d, err := renderPresenceData(l.presenceData, &PresenceTemplateData{UserNumber: userNum, ClientID: clientID, Channel: channel})
+			if err != nil {
+				l.log.Debug("error rendering presence data", "channel", channel, "err", err)
+				l.w.recorder.RecordFailure(name, 0, err)
+				return err
+			}
+			data = d
+		}
+		startTime := timeNow()
+		err := op(data)
+		elapsedTime := timeNow() - startTime
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		} else if err != nil {
+			l.log.Debug("presence operation failed", "op", name, "channel", channel, "elapsedTime", elapsedTime, "err", err)
+			l.w.recorder.RecordFailure(name, elapsedTime, err)
+			return err
+		}
+		l.log.Debug("presence operation succeeded", "op", name, "channel", channel, "elapsedTime", elapsedTime)
+		l.w.recorder.RecordSuccess(name, elapsedTime, 0)
+		return nil
+	}
+	enter := func(data interface{}) error {
+		if presence != nil {
+			return presence.EnterWithData(ctx, channel, clientID, data)
+		}
+		return client.Enter(ctx, channel, clientID)
+	}
+	update := func(data interface{}) error {
+		return presence.UpdateWithData(ctx, channel, clientID, data)
+	}
+	leave := func(data interface{}) error {
+		return presence.LeaveWithData(ctx, channel, clientID, data)
+	}
+
+	var updateC <-chan time.Time
+	if conf.UpdateInterval > 0 {
+		ticker := time.NewTicker(conf.UpdateInterval)
+		defer ticker.Stop()
+		updateC = ticker.C
+	}
+
+	// wait a random amount of time before the first leave so that users
+	// don't all churn at the same time
+	var churnDelay time.Duration
+	if conf.ChurnInterval > 0 {
+		churnDelay = time.Duration(rand.Int63n(int64(conf.ChurnInterval)))
+	}
+
+	for {
+		// enter, retrying every second
+		for {
+			l.log.Debug("entering", "channel", channel)
+			err := do("presence", enter)
+			if err == nil {
+				l.presenceMembers.set(channel, clientID, true)
+				break
+			} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				l.log.Debug("presence stopped", "channel", channel)
+				return nil
+			}
+			// try again in a second
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		// update until it's time to leave or the context is done
+		var leaveC <-chan time.Time
+		if conf.ChurnInterval > 0 {
+			leaveC = time.After(conf.ChurnInterval + churnDelay)
+			churnDelay = 0
+		}
+	present:
+		for {
+			select {
+			case <-updateC:
+				l.log.Debug("updating presence", "channel", channel)
+				do("presenceUpdate", update)
+			case <-leaveC:
+				l.log.Debug("leaving", "channel", channel)
+				l.presenceMembers.set(channel, clientID, false)
+				do("presenceLeave", leave)
+				break present
+			case <-ctx.Done():
+				l.log.Debug("presence stopped", "channel", channel)
+				return nil
+			}
+		}
+
+		// wait before re-entering
+		select {
+		case <-time.After(conf.ChurnAbsence):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+var (
+	errPresenceMemberMissing = errors.New("expected presence member missing")
+	errPresenceMemberExtra   = errors.New("unexpected presence member present")
+)
+
+// PresenceSubscribeClient is an optional interface implemented by Clients
+// which can subscribe to presence events and get the presence set of a
+// channel, and is required to run presence subscriber tasks.
+type PresenceSubscribeClient interface {
+	// SubscribePresence subscribes to presence events on the given channel
+	// and calls the given handler for each presence message received.
+	SubscribePresence(ctx context.Context, channel string, handler func(*ably.PresenceMessage)) error
+
+	// GetPresence gets the current presence members of the given channel.
+	GetPresence(ctx context.Context, channel string) ([]*ably.PresenceMessage, error)
+}
+
+// runPresenceSubscriber runs a presence subscriber task which renders the
+// channel names using the given user number, subscribes to presence events
+// on each of them and, if conf.PresenceSubscriber.CheckInterval is set,
+// periodically checks the presence set of each of them against the expected
+// members (see loadTest.checkPresence).
+//
+// The latency of each enter, update and leave event is recorded as
+// "presenceSubscribe", measured from the time included in the presence data
+// by the member sending it.
+func (l *loadTest) runPresenceSubscriber(ctx context.Context, client Client, userNum int64) error {
+	conf := l.w.Conf()
+	channels := renderChannels(l.presenceSubscriberChannels, userNum)
+
+	presence, ok := client.(PresenceSubscribeClient)
+	if !ok {
+		err := fmt.Errorf("client %q does not support subscribing to presence", conf.Client)
+		l.w.recorder.RecordFailure("presenceSubscribe", 0, err)
+		return err
+	}
+
+	l.log.Debug("starting presence subscriber", "channels", channels, "checkInterval", conf.PresenceSubscriber.CheckInterval)
+
+	errG, ctx := errgroup.WithContext(ctx)
+	families := channelFamilies(conf.PresenceSubscriber.Channels, channels)
+	for i := range channels {
+		channel := channels[i]
+		ctx := withChannelFamily(ctx, families[i])
+		errG.Go(func() error {
+			for {
+				l.log.Debug("subscribing to presence", "channel", channel)
+				err := presence.SubscribePresence(ctx, channel, func(msg *ably.PresenceMessage) {
+					switch msg.Action {
+					case ably.PresenceActionEnter, ably.PresenceActionUpdate, ably.PresenceActionLeave:
+					default:
+						// ignore members synced when attaching
+						return
+					}
+					if msg.Data == nil || msg.Data == "" {
+						// the member didn't send any data, so there's
+						// no time to measure the latency from
+						l.log.Debug("ignoring presence message without data", "channel", channel, "clientID", msg.ClientID)
+						return
+					}
+					data, size, err := decodeMessage(msg.Data)
+					if err != nil {
+						l.log.Debug("error parsing presence data", "err", err)
+						l.w.recorder.RecordFailure("presenceSubscribe", 0, err)
+						return
+					}
+					latency := timeNow() - data.Data.Time
+					l.log.Debug("presence subscriber received message", "channel", channel, "action", msg.Action, "clientID", msg.ClientID, "latency", latency)
+					l.w.recorder.RecordSuccess("presenceSubscribe", latency, size)
+				})
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					l.log.Debug("presence subscriber stopped")
+					return nil
+				} else if err != nil {
+					l.log.Debug("error subscribing to presence", "channel", channel, "err", err)
+					l.w.recorder.RecordFailure("presenceSubscribe", 0, err)
+					// try again in a second
+					select {
+					case <-time.After(time.Second):
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			}
+		})
+
+		if interval := conf.PresenceSubscriber.CheckInterval; interval > 0 {
+			errG.Go(func() error {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						l.checkPresence(ctx, presence, channel)
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			})
+		}
+	}
+	return errG.Wait()
+}
+
+// checkPresence gets the presence set of the given channel and compares it
+// against the expected members (see loadTest.expectedPresence).
+//
+// The latency of getting the presence set is recorded as "presenceGet", with
+// each missing member recorded as a "presenceMissing" failure and each
+// unexpected member recorded as a "presenceExtra" failure.
+func (l *loadTest) checkPresence(ctx context.Context, presence PresenceSubscribeClient, channel string) {
+	l.log.Debug("getting presence", "channel", channel)
+	startTime := timeNow()
+	members, err := presence.GetPresence(ctx, channel)
+	elapsedTime := timeNow() - startTime
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	} else if err != nil {
+		l.log.Debug("error getting presence", "channel", channel, "elapsedTime", elapsedTime, "err", err)
+		l.w.recorder.RecordFailure("presenceGet", elapsedTime, err)
+		return
+	}
+	l.w.recorder.RecordSuccess("presenceGet", elapsedTime, int64(len(members)))
+
+	expected := l.expectedPresence(channel)
+	present := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		present[member.ClientID] = struct{}{}
+		if _, ok := expected[member.ClientID]; !ok {
+			l.log.Debug("unexpected presence member", "channel", channel, "clientID", member.ClientID)
+			l.w.recorder.RecordFailure("presenceExtra", 0, errPresenceMemberExtra)
+		}
+	}
+	for clientID, expectPresent := range expected {
+		if _, ok := present[clientID]; !ok && expectPresent {
+			l.log.Debug("missing presence member", "channel", channel, "clientID", clientID)
+			l.w.recorder.RecordFailure("presenceMissing", 0, errPresenceMemberMissing)
+		}
+	}
+}
+
+// expectedPresence returns the clientIDs of the members expected in the given
+// channel, mapped to whether they are expected to be present (members which
+// map to false may or may not be present, for example whilst they are
+// leaving, so are neither missing nor unexpected).
+//
+// If conf.PresenceSubscriber.Users is set, the expected members are the users
+// 1 to conf.PresenceSubscriber.Users whose rendered conf.Presence.Channels
+// include the channel, which is only rendered once per channel. Otherwise
+// they are the members which users started by this worker have entered (see
+// presenceMembers).
+func (l *loadTest) expectedPresence(channel string) map[string]bool {
+	users := l.w.Conf().PresenceSubscriber.Users
+	if users <= 0 {
+		return l.presenceMembers.get(channel)
+	}
+	return l.presenceMembers.static(channel, func() map[string]bool {
+		expected := make(map[string]bool)
+		for userNum := int64(1); userNum <= users; userNum++ {
+			for _, c := range renderChannels(l.presenceChannels, userNum) {
+				if c == channel {
+					expected[l.clientID(userNum)] = true
+					break
+				}
+			}
+		}
+		return expected
+	})
+}
+
+// presenceMembers tracks the members that users have entered into each
+// presence channel, so that presence subscribers can check the presence set
+// of each channel against the expected members.
+type presenceMembers struct {
+	mtx      sync.Mutex
+	channels map[string]map[string]bool
+	statics  map[string]map[string]bool
+}
+
+// set sets whether the given member is expected to be present in the given
+// channel.
+func (p *presenceMembers) set(channel, clientID string, present bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.channels == nil {
+		p.channels = make(map[string]map[string]bool)
+	}
+	members, ok := p.channels[channel]
+	if !ok {
+		members = make(map[string]bool)
+		p.channels[channel] = members
+	}
+	members[clientID] = present
+}
+
+// get returns a copy of the members of the given channel, mapped to whether
+// they are expected to be present.
+func (p *presenceMembers) get(channel string) map[string]bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	members := make(map[string]bool, len(p.channels[channel]))
+	for clientID, present := range p.channels[channel] {
+		members[clientID] = present
+	}
+	return members
+}
+
+// static returns the static set of expected members of the given channel,
+// calling the given function to determine them the first time the channel
+// is requested.
+func (p *presenceMembers) static(channel string, expected func() map[string]bool) map[string]bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.statics == nil {
+		p.statics = make(map[string]map[string]bool)
+	}
+	members, ok := p.statics[channel]
+	if !ok {
+		members = expected()
+		p.statics[channel] = members
+	}
+	return members
+}
@@ -0,0 +1,234 @@
+package ablyboomer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-go/ably"
+	"github.com/inconshreveable/log15"
+)
+
+// TestRunPresenceChannel tests entering, updating and churning presence with
+// templated presence data.
+func TestRunPresenceChannel(t *testing.T) {
+	conf := config.Default()
+	conf.Presence.Data = `{"room": {{ mod .UserNumber 4 }}, "client": "{{ .ClientID }}"}`
+	conf.Presence.UpdateInterval = 10 * time.Millisecond
+	conf.Presence.ChurnInterval = 50 * time.Millisecond
+	conf.Presence.ChurnAbsence = 10 * time.Millisecond
+	data, err := parsePresenceData(&conf.Presence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewSummaryRecorder()
+	l := &loadTest{
+		w:            &Worker{conf: conf, recorder: recorder},
+		presenceData: data,
+		log:          log15.New(),
+	}
+	l.log.SetHandler(log15.DiscardHandler())
+
+	client := &testPresenceClient{}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := l.runPresenceChannel(ctx, &testClient{}, client, "test", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// check each operation was called and recorded
+	client.mtx.Lock()
+	defer client.mtx.Unlock()
+	for _, op := range []struct {
+		name  string
+		count int
+	}{
+		{"presence", client.enters},
+		{"presenceUpdate", client.updates},
+		{"presenceLeave", client.leaves},
+	} {
+		if op.count == 0 {
+			t.Fatalf("expected at least one %s operation", op.name)
+		}
+		if stats := taskStats(recorder, op.name); stats.Count != int64(op.count) {
+			t.Fatalf("expected %d %s successes, got %d", op.count, op.name, stats.Count)
+		}
+	}
+	if client.enters < client.leaves {
+		t.Fatalf("expected at least as many enters as leaves, got %d enters and %d leaves", client.enters, client.leaves)
+	}
+
+	// check the member is no longer expected to be present once stopped
+	if members := l.presenceMembers.get("test"); len(members) != 1 || members["user5"] {
+		t.Fatalf("expected user5 not to be expected present, got %v", members)
+	}
+
+	// check the presence data was rendered with the enter time
+	var msg Data
+	if err := json.Unmarshal([]byte(client.data.(string)), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != `{"room": 1, "client": "user5"}` {
+		t.Fatalf("unexpected presence data content: %q", msg.Content)
+	}
+	if msg.Time == 0 {
+		t.Fatal("expected presence data to include the time")
+	}
+}
+
+// testPresenceClient is a PresenceClient which counts presence operations
+// and stores the last presence data.
+type testPresenceClient struct {
+	mtx     sync.Mutex
+	enters  int
+	updates int
+	leaves  int
+	data    interface{}
+}
+
+func (t *testPresenceClient) EnterWithData(ctx context.Context, channel, clientID string, data interface{}) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.enters++
+	t.data = data
+	return nil
+}
+
+func (t *testPresenceClient) UpdateWithData(ctx context.Context, channel, clientID string, data interface{}) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.updates++
+	t.data = data
+	return nil
+}
+
+func (t *testPresenceClient) LeaveWithData(ctx context.Context, channel, clientID string, data interface{}) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.leaves++
+	t.data = data
+	return nil
+}
+
+// TestPresenceSubscriber tests measuring presence event latency and checking
+// presence sets against the expected members.
+func TestPresenceSubscriber(t *testing.T) {
+	conf := config.Default()
+	conf.Presence.Channels = "room-{{ mod .UserNumber 2 }}"
+	conf.PresenceSubscriber.Channels = "room-1"
+	conf.PresenceSubscriber.CheckInterval = 10 * time.Millisecond
+	conf.PresenceSubscriber.Users = 4
+	presenceChannels, err := template.New("channel").Funcs(channelFuncs).Parse(conf.Presence.Channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	presenceSubscriberChannels, err := template.New("channel").Funcs(channelFuncs).Parse(conf.PresenceSubscriber.Channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewSummaryRecorder()
+	l := &loadTest{
+		w:                          &Worker{conf: conf, recorder: recorder},
+		presenceChannels:           presenceChannels,
+		presenceSubscriberChannels: presenceSubscriberChannels,
+		log:                        log15.New(),
+	}
+	l.log.SetHandler(log15.DiscardHandler())
+
+	// users 1 and 3 are expected in room-1, so return user 5 instead of
+	// user 3
+	enterData, err := renderPresenceData(nil, &PresenceTemplateData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testPresenceSubscribeClient{
+		events: []*ably.PresenceMessage{
+			{Action: ably.PresenceActionPresent, Message: ably.Message{ClientID: "user1", Data: enterData}},
+			{Action: ably.PresenceActionEnter, Message: ably.Message{ClientID: "user5", Data: enterData}},
+			{Action: ably.PresenceActionEnter, Message: ably.Message{ClientID: "user6", Data: ""}},
+		},
+		members: []*ably.PresenceMessage{
+			{Action: ably.PresenceActionPresent, Message: ably.Message{ClientID: "user1"}},
+			{Action: ably.PresenceActionPresent, Message: ably.Message{ClientID: "user5"}},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	if err := l.runPresenceSubscriber(ctx, client, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// check only the enter event with data was measured
+	if stats := taskStats(recorder, "presenceSubscribe"); stats.Count != 1 || stats.Failures != 0 {
+		t.Fatalf("expected 1 presenceSubscribe success, got %+v", stats)
+	}
+
+	// check each presence check reported user 3 missing and user 5 extra
+	gets := taskStats(recorder, "presenceGet").Count
+	if gets == 0 {
+		t.Fatal("expected at least one presenceGet success")
+	}
+	if stats := taskStats(recorder, "presenceMissing"); stats.Failures != gets {
+		t.Fatalf("expected %d presenceMissing failures, got %d", gets, stats.Failures)
+	}
+	if stats := taskStats(recorder, "presenceExtra"); stats.Failures != gets {
+		t.Fatalf("expected %d presenceExtra failures, got %d", gets, stats.Failures)
+	}
+}
+
+// TestCheckPresenceEnteredMembers tests checking presence sets against the
+// members that users have entered.
+func TestCheckPresenceEnteredMembers(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	l := &loadTest{
+		w:   &Worker{conf: config.Default(), recorder: recorder},
+		log: log15.New(),
+	}
+	l.log.SetHandler(log15.DiscardHandler())
+
+	// user1 has entered, user2 is leaving and user3 was never entered
+	l.presenceMembers.set("room", "user1", true)
+	l.presenceMembers.set("room", "user2", false)
+	l.presenceMembers.set("other", "user3", true)
+	client := &testPresenceSubscribeClient{
+		members: []*ably.PresenceMessage{
+			{Action: ably.PresenceActionPresent, Message: ably.Message{ClientID: "user2"}},
+			{Action: ably.PresenceActionPresent, Message: ably.Message{ClientID: "user3"}},
+		},
+	}
+	l.checkPresence(context.Background(), client, "room")
+
+	// check user1 is missing and user3 is unexpected, but user2 is
+	// neither
+	if stats := taskStats(recorder, "presenceMissing"); stats.Failures != 1 {
+		t.Fatalf("expected 1 presenceMissing failure, got %d", stats.Failures)
+	}
+	if stats := taskStats(recorder, "presenceExtra"); stats.Failures != 1 {
+		t.Fatalf("expected 1 presenceExtra failure, got %d", stats.Failures)
+	}
+}
+
+// testPresenceSubscribeClient is a PresenceSubscribeClient which sends
+// the given presence events when subscribed to, and returns the given members
+// as the presence set.
+type testPresenceSubscribeClient struct {
+	testClient
+	events  []*ably.PresenceMessage
+	members []*ably.PresenceMessage
+}
+
+func (t *testPresenceSubscribeClient) SubscribePresence(ctx context.Context, channel string, handler func(*ably.PresenceMessage)) error {
+	for _, event := range t.events {
+		handler(event)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (t *testPresenceSubscribeClient) GetPresence(ctx context.Context, channel string) ([]*ably.PresenceMessage, error) {
+	return t.members, nil
+}
@@ -0,0 +1,169 @@
+package ablyboomer
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// Supported values for conf.Publisher.RateMode.
+const (
+	PublishRateConstant = "constant"
+	PublishRatePoisson  = "poisson"
+	PublishRateBurst    = "burst"
+	PublishRateRamp     = "ramp"
+	PublishRateSteps    = "steps"
+)
+
+// publishRate determines when publishers publish messages to a channel.
+type publishRate interface {
+	// next returns how long to wait before publishing the next messages
+	// and how many messages to publish, given how long the load test has
+	// been running.
+	next(elapsed time.Duration) (time.Duration, int)
+}
+
+// newPublishRate returns the publishRate configured by conf.RateMode.
+func newPublishRate(conf *config.PublisherConfig) (publishRate, error) {
+	if conf.PublishInterval <= 0 {
+		return nil, fmt.Errorf("invalid publish interval: %v", conf.PublishInterval)
+	}
+	switch conf.RateMode {
+	case "", PublishRateConstant:
+		return &constantRate{interval: conf.PublishInterval}, nil
+	case PublishRatePoisson:
+		return &poissonRate{mean: conf.PublishInterval}, nil
+	case PublishRateBurst:
+		if conf.BurstSize < 1 {
+			return nil, fmt.Errorf("invalid burst size: %d", conf.BurstSize)
+		}
+		return &burstRate{interval: conf.PublishInterval, size: conf.BurstSize}, nil
+	case PublishRateRamp:
+		if conf.RampEndInterval <= 0 {
+			return nil, fmt.Errorf("invalid ramp end interval: %v", conf.RampEndInterval)
+		}
+		return &rampRate{
+			start:    conf.PublishInterval,
+			end:      conf.RampEndInterval,
+			duration: conf.RampDuration,
+		}, nil
+	case PublishRateSteps:
+		return parseStepRate(conf.Steps)
+	default:
+		return nil, fmt.Errorf("unknown rate mode: %q", conf.RateMode)
+	}
+}
+
+// constantRate publishes a single message every interval.
+type constantRate struct {
+	interval time.Duration
+}
+
+func (c *constantRate) next(time.Duration) (time.Duration, int) {
+	return c.interval, 1
+}
+
+// poissonRate publishes a single message with exponentially distributed
+// intervals with the given mean, simulating independently timed publishes
+// from a large number of clients.
+type poissonRate struct {
+	mean time.Duration
+}
+
+func (p *poissonRate) next(time.Duration) (time.Duration, int) {
+	return time.Duration(rand.ExpFloat64() * float64(p.mean)), 1
+}
+
+// burstRate publishes a burst of messages every interval.
+type burstRate struct {
+	interval time.Duration
+	size     int
+}
+
+func (b *burstRate) next(time.Duration) (time.Duration, int) {
+	return b.interval, b.size
+}
+
+// rampRate publishes a single message with the rate increasing (or
+// decreasing) linearly from one message every start interval to one message
+// every end interval over the given duration, and then publishing at the end
+// rate.
+type rampRate struct {
+	start    time.Duration
+	end      time.Duration
+	duration time.Duration
+}
+
+func (r *rampRate) next(elapsed time.Duration) (time.Duration, int) {
+	if elapsed >= r.duration {
+		return r.end, 1
+	}
+	startRate := 1 / r.start.Seconds()
+	endRate := 1 / r.end.Seconds()
+	rate := startRate + (endRate-startRate)*elapsed.Seconds()/r.duration.Seconds()
+	return time.Duration(float64(time.Second) / rate), 1
+}
+
+// rateStep is a step of a stepRate which publishes a single message every
+// interval for the given duration.
+type rateStep struct {
+	duration time.Duration
+	interval time.Duration
+}
+
+// stepRate publishes a single message at intervals which change according to
+// a schedule of steps, continuing at the interval of the last step once the
+// schedule is complete.
+type stepRate struct {
+	steps []rateStep
+}
+
+// parseStepRate parses a comma separated list of steps like:
+//
+//     30s:1s, 1m:500ms, 1m:100ms
+//
+// which is the duration of each step and the interval between messages
+// during that step.
+func parseStepRate(s string) (*stepRate, error) {
+	var steps []rateStep
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			continue
+		}
+		parts := strings.SplitN(step, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid step %q, expected a step like '30s:1s'", step)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %q, %v", step, err)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %q, %v", step, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid step %q, interval must be positive", step)
+		}
+		steps = append(steps, rateStep{duration: duration, interval: interval})
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("no steps configured")
+	}
+	return &stepRate{steps: steps}, nil
+}
+
+func (s *stepRate) next(elapsed time.Duration) (time.Duration, int) {
+	for _, step := range s.steps {
+		if elapsed < step.duration {
+			return step.interval, 1
+		}
+		elapsed -= step.duration
+	}
+	return s.steps[len(s.steps)-1].interval, 1
+}
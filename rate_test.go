@@ -0,0 +1,117 @@
+package ablyboomer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// TestPublishRate tests the delays and message counts of each publish rate
+// mode.
+func TestPublishRate(t *testing.T) {
+	type expected struct {
+		elapsed time.Duration
+		delay   time.Duration
+		count   int
+	}
+	for _, test := range []struct {
+		name     string
+		conf     config.PublisherConfig
+		expected []expected
+	}{
+		{
+			name: "constant",
+			conf: config.PublisherConfig{RateMode: PublishRateConstant, PublishInterval: time.Second},
+			expected: []expected{
+				{0, time.Second, 1},
+				{time.Hour, time.Second, 1},
+			},
+		},
+		{
+			name: "burst",
+			conf: config.PublisherConfig{RateMode: PublishRateBurst, PublishInterval: time.Second, BurstSize: 5},
+			expected: []expected{
+				{0, time.Second, 5},
+			},
+		},
+		{
+			name: "ramp",
+			conf: config.PublisherConfig{
+				RateMode:        PublishRateRamp,
+				PublishInterval: time.Second,
+				RampEndInterval: 100 * time.Millisecond,
+				RampDuration:    time.Minute,
+			},
+			expected: []expected{
+				{0, time.Second, 1},
+				{30 * time.Second, 181818181 * time.Nanosecond, 1}, // 5.5 msgs/sec
+				{time.Minute, 100 * time.Millisecond, 1},
+				{time.Hour, 100 * time.Millisecond, 1},
+			},
+		},
+		{
+			name: "steps",
+			conf: config.PublisherConfig{
+				RateMode:        PublishRateSteps,
+				PublishInterval: time.Second,
+				Steps:           "30s:1s, 1m:500ms,1m:100ms",
+			},
+			expected: []expected{
+				{0, time.Second, 1},
+				{30 * time.Second, 500 * time.Millisecond, 1},
+				{89 * time.Second, 500 * time.Millisecond, 1},
+				{90 * time.Second, 100 * time.Millisecond, 1},
+				{time.Hour, 100 * time.Millisecond, 1},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rate, err := newPublishRate(&test.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expected {
+				delay, count := rate.next(e.elapsed)
+				if diff := delay - e.delay; diff < -time.Millisecond || diff > time.Millisecond || count != e.count {
+					t.Fatalf("expected %v and %d messages after %v, got %v and %d", e.delay, e.count, e.elapsed, delay, count)
+				}
+			}
+		})
+	}
+
+	t.Run("poisson", func(t *testing.T) {
+		rate, err := newPublishRate(&config.PublisherConfig{RateMode: PublishRatePoisson, PublishInterval: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var total time.Duration
+		n := 10000
+		for i := 0; i < n; i++ {
+			delay, count := rate.next(0)
+			if delay < 0 || count != 1 {
+				t.Fatalf("unexpected delay %v and count %d", delay, count)
+			}
+			total += delay
+		}
+		if mean := total / time.Duration(n); mean < 90*time.Millisecond || mean > 110*time.Millisecond {
+			t.Fatalf("expected a mean delay of around 100ms, got %v", mean)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, conf := range []config.PublisherConfig{
+			{RateMode: "unknown", PublishInterval: time.Second},
+			{RateMode: PublishRateConstant},
+			{RateMode: PublishRateBurst, PublishInterval: time.Second},
+			{RateMode: PublishRateRamp, PublishInterval: time.Second},
+			{RateMode: PublishRateSteps, PublishInterval: time.Second},
+			{RateMode: PublishRateSteps, PublishInterval: time.Second, Steps: "30s"},
+			{RateMode: PublishRateSteps, PublishInterval: time.Second, Steps: "30s:0s"},
+		} {
+			if _, err := newPublishRate(&conf); err == nil {
+				t.Fatalf("expected an error for %+v", conf)
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package ablyboomer
+
+import (
+	"github.com/myzhan/boomer"
+)
+
+// requestType is the request type that stats are reported to Locust with.
+const requestType = "ablyboomer"
+
+// Recorder records the results of the requests made by load test tasks and
+// clients (e.g. receiving a message for the "subscribe" task, or publishing
+// a message for the "publish" task).
+//
+// Response times are in milliseconds and response lengths are in bytes.
+type Recorder interface {
+	// RecordSuccess records a successful request with the given name.
+	RecordSuccess(name string, responseTime, responseLength int64)
+
+	// RecordFailure records a failed request with the given name.
+	RecordFailure(name string, responseTime int64, err error)
+}
+
+// boomerRecorder is a Recorder that reports stats to Locust via a boomer
+// instance.
+type boomerRecorder struct {
+	boomer *boomer.Boomer
+}
+
+// NewBoomerRecorder returns a Recorder that reports stats to Locust using
+// the given boomer instance, and is the Recorder a Worker uses by default.
+func NewBoomerRecorder(b *boomer.Boomer) Recorder {
+	return &boomerRecorder{boomer: b}
+}
+
+// RecordSuccess implements the Recorder interface.
+func (b *boomerRecorder) RecordSuccess(name string, responseTime, responseLength int64) {
+	b.boomer.RecordSuccess(requestType, name, responseTime, responseLength)
+}
+
+// RecordFailure implements the Recorder interface.
+func (b *boomerRecorder) RecordFailure(name string, responseTime int64, err error) {
+	b.boomer.RecordFailure(requestType, name, responseTime, err.Error())
+}
+
+// fanoutRecorder is a Recorder that records to multiple Recorders.
+type fanoutRecorder []Recorder
+
+// NewFanoutRecorder returns a Recorder that records to each of the given
+// Recorders in turn.
+func NewFanoutRecorder(recorders ...Recorder) Recorder {
+	return fanoutRecorder(recorders)
+}
+
+// RecordSuccess implements the Recorder interface.
+func (f fanoutRecorder) RecordSuccess(name string, responseTime, responseLength int64) {
+	for _, r := range f {
+		r.RecordSuccess(name, responseTime, responseLength)
+	}
+}
+
+// RecordFailure implements the Recorder interface.
+func (f fanoutRecorder) RecordFailure(name string, responseTime int64, err error) {
+	for _, r := range f {
+		r.RecordFailure(name, responseTime, err)
+	}
+}
@@ -0,0 +1,49 @@
+package ablyboomer
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestFanoutRecorder tests recording stats to multiple Recorders via a
+// fanout Recorder.
+func TestFanoutRecorder(t *testing.T) {
+	first := NewSummaryRecorder()
+	second := NewSummaryRecorder()
+	recorder := NewFanoutRecorder(first, second)
+
+	recorder.RecordSuccess("subscribe", 20, 100)
+	recorder.RecordSuccess("subscribe", 10, 100)
+	recorder.RecordSuccess("subscribe", 30, 100)
+	recorder.RecordFailure("subscribe", 0, errors.New("timeout"))
+	recorder.RecordFailure("subscribe", 0, errors.New("timeout"))
+	recorder.RecordFailure("publish", 0, errors.New("rate limited"))
+
+	for _, r := range []*SummaryRecorder{first, second} {
+		stats := taskStats(r, "subscribe")
+		if stats.Count != 3 {
+			t.Fatalf("expected 3 successes, got %d", stats.Count)
+		}
+		if stats.Failures != 2 {
+			t.Fatalf("expected 2 failures, got %d", stats.Failures)
+		}
+		if stats.Bytes != 300 {
+			t.Fatalf("expected 300 bytes, got %d", stats.Bytes)
+		}
+		if count := stats.FailuresByMessage["timeout"]; count != 2 {
+			t.Fatalf("expected 2 timeout failures, got %d", count)
+		}
+		if stats := taskStats(r, "publish"); stats.Failures != 1 {
+			t.Fatalf("expected 1 publish failure, got %d", stats.Failures)
+		}
+	}
+}
+
+// taskStats returns the stats recorded by the given SummaryRecorder for the
+// given name, which are empty if no stats were recorded with that name.
+func taskStats(recorder *SummaryRecorder, name string) *TaskSummary {
+	if task := recorder.Summary().Task(name); task != nil {
+		return task
+	}
+	return &TaskSummary{Name: name, FailuresByMessage: map[string]int64{}}
+}
@@ -0,0 +1,203 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Supported values for conf.Results.Format.
+const (
+	ResultsFormatCSV   = "csv"
+	ResultsFormatJSONL = "jsonl"
+)
+
+// resultsCSVHeader is the header row of a CSV results file, which matches the
+// JSON field names of ResultsRow.
+var resultsCSVHeader = []string{
+	"time", "worker", "name", "count", "failures", "min", "mean", "p50", "p90", "p99", "p99.9", "max", "bytes",
+}
+
+// ResultsRow is the stats recorded with a single name by a single worker
+// during an interval of a load test, with response times in milliseconds.
+type ResultsRow struct {
+	Time     time.Time `json:"time"`
+	Worker   int64     `json:"worker"`
+	Name     string    `json:"name"`
+	Count    int64     `json:"count"`
+	Failures int64     `json:"failures"`
+	Min      int64     `json:"min"`
+	Mean     float64   `json:"mean"`
+	P50      int64     `json:"p50"`
+	P90      int64     `json:"p90"`
+	P99      int64     `json:"p99"`
+	P999     int64     `json:"p99.9"`
+	Max      int64     `json:"max"`
+	Bytes    int64     `json:"bytes"`
+}
+
+// ResultsWriter writes per-interval results rows as either CSV or JSON Lines.
+type ResultsWriter struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+}
+
+// NewResultsWriter returns a ResultsWriter that writes rows in the given
+// format to w, writing the header row first if the format is CSV.
+func NewResultsWriter(w io.Writer, format string) (*ResultsWriter, error) {
+	r := &ResultsWriter{w: w, format: format}
+	switch format {
+	case ResultsFormatCSV:
+		r.csv = csv.NewWriter(w)
+		if err := r.csv.Write(resultsCSVHeader); err != nil {
+			return nil, err
+		}
+		r.csv.Flush()
+		if err := r.csv.Error(); err != nil {
+			return nil, err
+		}
+	case ResultsFormatJSONL:
+	default:
+		return nil, fmt.Errorf("unknown results format: %q", format)
+	}
+	return r, nil
+}
+
+// WriteSummary writes a row for each task in the given interval summary,
+// timestamped with the end of the interval.
+func (r *ResultsWriter) WriteSummary(worker int64, summary *Summary) error {
+	end := summary.Start.Add(summary.Duration)
+	for _, t := range summary.Tasks {
+		row := &ResultsRow{
+			Time:     end,
+			Worker:   worker,
+			Name:     t.Name,
+			Count:    t.Count,
+			Failures: t.Failures,
+			Min:      t.Min,
+			Mean:     t.Mean,
+			P50:      t.P50,
+			P90:      t.P90,
+			P99:      t.P99,
+			P999:     t.P999,
+			Max:      t.Max,
+			Bytes:    t.Bytes,
+		}
+		if err := r.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRow writes a single row.
+func (r *ResultsWriter) WriteRow(row *ResultsRow) error {
+	if r.csv == nil {
+		return json.NewEncoder(r.w).Encode(row)
+	}
+	r.csv.Write([]string{
+		row.Time.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(row.Worker, 10),
+		row.Name,
+		strconv.FormatInt(row.Count, 10),
+		strconv.FormatInt(row.Failures, 10),
+		strconv.FormatInt(row.Min, 10),
+		strconv.FormatFloat(row.Mean, 'f', 3, 64),
+		strconv.FormatInt(row.P50, 10),
+		strconv.FormatInt(row.P90, 10),
+		strconv.FormatInt(row.P99, 10),
+		strconv.FormatInt(row.P999, 10),
+		strconv.FormatInt(row.Max, 10),
+		strconv.FormatInt(row.Bytes, 10),
+	})
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+// resultsFileData is the data used to render the conf.Results.File template.
+type resultsFileData struct {
+	WorkerNumber int64
+}
+
+// resultsFilePath renders the conf.Results.File template with the worker's
+// number so that workers sharing a filesystem can write to separate files.
+func (w *Worker) resultsFilePath() (string, error) {
+	tmpl, err := template.New("results").Parse(w.conf.Results.File)
+	if err != nil {
+		return "", err
+	}
+	var path bytes.Buffer
+	if err := tmpl.Execute(&path, resultsFileData{WorkerNumber: w.number}); err != nil {
+		return "", err
+	}
+	return path.String(), nil
+}
+
+// startResultsExport opens the results file and starts writing a row for
+// each task every conf.Results.Interval, returning a function which writes
+// the final interval and closes the file.
+//
+// Errors are logged rather than returned so that a load test isn't prevented
+// from running because results can't be exported.
+func (w *Worker) startResultsExport() func() {
+	path, err := w.resultsFilePath()
+	if err != nil {
+		w.log.Error("error parsing results.file", "file", w.conf.Results.File, "err", err)
+		return func() {}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		w.log.Error("error creating results file", "path", path, "err", err)
+		return func() {}
+	}
+	writer, err := NewResultsWriter(f, w.conf.Results.Format)
+	if err != nil {
+		w.log.Error("error writing results file", "path", path, "err", err)
+		f.Close()
+		return func() {}
+	}
+	w.log.Info("exporting results", "path", path, "format", w.conf.Results.Format, "interval", w.conf.Results.Interval)
+
+	write := func() {
+		if err := writer.WriteSummary(w.number, w.results.Rotate()); err != nil {
+			w.log.Error("error writing results", "path", path, "err", err)
+		}
+	}
+
+	// start a new interval from now rather than from when the worker was
+	// initialised
+	w.results.Reset()
+
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		ticker := time.NewTicker(w.conf.Results.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				write()
+			case <-stopC:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		w.log.Debug("stopping results export")
+		close(stopC)
+		<-doneC
+		write()
+		if err := f.Close(); err != nil {
+			w.log.Error("error closing results file", "path", path, "err", err)
+		}
+	}
+}
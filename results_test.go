@@ -0,0 +1,92 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestResultsWriter tests writing per-interval results rotated from a
+// SummaryRecorder as CSV and JSON Lines.
+func TestResultsWriter(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	recorder.RecordSuccess("subscribe", 10, 100)
+	recorder.RecordSuccess("subscribe", 20, 100)
+	recorder.RecordFailure("publish", 0, errors.New("timeout"))
+	first := recorder.Rotate()
+	recorder.RecordSuccess("subscribe", 30, 100)
+	second := recorder.Rotate()
+
+	// check rotating starts a new interval without losing stats
+	if task := first.Task("subscribe"); task == nil || task.Count != 2 {
+		t.Fatalf("expected 2 subscribe successes in the first interval, got %v", task)
+	}
+	if len(second.Tasks) != 1 || second.Tasks[0].Count != 1 || second.Tasks[0].Min != 30 {
+		t.Fatalf("expected 1 subscribe success in the second interval, got %v", second.Tasks)
+	}
+	if !second.Start.Equal(first.Start.Add(first.Duration)) {
+		t.Fatalf("expected second interval to start at %v, got %v", first.Start.Add(first.Duration), second.Start)
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer, err := NewResultsWriter(&buf, ResultsFormatCSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WriteSummary(3, first); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WriteSummary(3, second); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 4 {
+			t.Fatalf("expected a header and 3 rows, got %v", records)
+		}
+		if strings.Join(records[0], ",") != strings.Join(resultsCSVHeader, ",") {
+			t.Fatalf("unexpected header: %v", records[0])
+		}
+		row := records[2]
+		if row[1] != "3" || row[2] != "subscribe" || row[3] != "2" || row[5] != "10" || row[11] != "20" || row[12] != "200" {
+			t.Fatalf("unexpected subscribe row: %v", row)
+		}
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer, err := NewResultsWriter(&buf, ResultsFormatJSONL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WriteSummary(3, first); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 rows, got %q", buf.String())
+		}
+		var row ResultsRow
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+			t.Fatal(err)
+		}
+		if row.Worker != 3 || row.Name != "publish" || row.Failures != 1 {
+			t.Fatalf("unexpected publish row: %+v", row)
+		}
+		if !row.Time.Equal(first.Start.Add(first.Duration)) {
+			t.Fatalf("expected row time to be the end of the interval, got %v", row.Time)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if _, err := NewResultsWriter(&bytes.Buffer{}, "xml"); err == nil {
+			t.Fatal("expected an error for an unknown format")
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package ablyboomer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errMessageLost       = errors.New("message lost")
+	errMessageDuplicate  = errors.New("duplicate message")
+	errMessageOutOfOrder = errors.New("message out of order")
+)
+
+// sequenceKey identifies a stream of sequenced messages published by a
+// single publisher to a single channel.
+type sequenceKey struct {
+	publisherID string
+	channel     string
+}
+
+// sequenceStream tracks the sequence numbers received from a single stream.
+type sequenceStream struct {
+	// first is the lowest sequence number received
+	first int64
+
+	// next is the sequence number expected next
+	next int64
+
+	// missing are the sequence numbers lower than next which haven't been
+	// received, mapped to when they were first missed
+	missing map[int64]time.Time
+
+	// lost are the missing sequence numbers which have been reported as
+	// lost, so that if they eventually arrive they are reported as out of
+	// order rather than as duplicates
+	lost map[int64]struct{}
+
+	// lastSeen is when a message was last received from the stream
+	lastSeen time.Time
+}
+
+// sequenceTracker tracks the sequence numbers of messages received by a
+// subscriber task to detect messages that are lost, duplicated or received
+// out of order, recording them as "messageLoss", "duplicate" and
+// "outOfOrder" failures respectively.
+//
+// Tracking of each stream starts from the first sequence number received so
+// that subscribers which start after a publisher don't report the messages
+// published before they subscribed as lost, with messages received later with
+// lower sequence numbers reported as out of order.
+//
+// A message is only reported as lost once it has been missing for the grace
+// period, which allows for messages being received out of order, and means
+// that messages which are in flight when the load test is stopped aren't
+// reported as lost. Messages which the publisher failed to publish (see
+// Data.Failed) aren't reported as lost, since the publisher already recorded
+// them as failures.
+//
+// A stream is forgotten once nothing has been received from it and none of
+// its messages have been missing for the grace period, so that streams from
+// publishers which have stopped don't accumulate.
+type sequenceTracker struct {
+	recorder Recorder
+	grace    time.Duration
+
+	mtx     sync.Mutex
+	streams map[sequenceKey]*sequenceStream
+}
+
+// newSequenceTracker returns a sequenceTracker which records to the given
+// Recorder and reports messages as lost once they have been missing for the
+// given grace period.
+func newSequenceTracker(recorder Recorder, grace time.Duration) *sequenceTracker {
+	return &sequenceTracker{
+		recorder: recorder,
+		grace:    grace,
+		streams:  make(map[sequenceKey]*sequenceStream),
+	}
+}
+
+// track tracks a message with the given sequence number received at the
+// given time, which follows the given number of messages which the publisher
+// failed to publish.
+func (s *sequenceTracker) track(publisherID, channel string, seq, failed int64, now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := sequenceKey{publisherID: publisherID, channel: channel}
+	stream, ok := s.streams[key]
+	if !ok {
+		s.streams[key] = &sequenceStream{
+			first:    seq,
+			next:     seq + 1,
+			missing:  make(map[int64]time.Time),
+			lost:     make(map[int64]struct{}),
+			lastSeen: now,
+		}
+		return
+	}
+	stream.lastSeen = now
+
+	switch {
+	case seq == stream.next:
+		stream.next++
+	case seq > stream.next:
+		for missed := stream.next; missed < seq; missed++ {
+			stream.missing[missed] = now
+		}
+		stream.next = seq + 1
+	case seq < stream.first:
+		// the message was published before the first message received,
+		// so it is out of order, and any messages published between
+		// them are now missing
+		for missed := seq + 1; missed < stream.first; missed++ {
+			stream.missing[missed] = now
+		}
+		stream.first = seq
+		s.recorder.RecordFailure("outOfOrder", 0, errMessageOutOfOrder)
+	default:
+		if _, ok := stream.missing[seq]; ok {
+			delete(stream.missing, seq)
+			s.recorder.RecordFailure("outOfOrder", 0, errMessageOutOfOrder)
+		} else if _, ok := stream.lost[seq]; ok {
+			delete(stream.lost, seq)
+			s.recorder.RecordFailure("outOfOrder", 0, errMessageOutOfOrder)
+		} else {
+			s.recorder.RecordFailure("duplicate", 0, errMessageDuplicate)
+		}
+	}
+	stream.skip(seq-failed, seq)
+}
+
+// skip stops tracking the missing sequence numbers from start up to but not
+// including end since they failed to publish.
+func (stream *sequenceStream) skip(start, end int64) {
+	if end-start > int64(len(stream.missing)) {
+		for seq := range stream.missing {
+			if seq >= start && seq < end {
+				delete(stream.missing, seq)
+			}
+		}
+		return
+	}
+	for seq := start; seq < end; seq++ {
+		delete(stream.missing, seq)
+	}
+}
+
+// expire reports messages which have been missing for longer than the grace
+// period at the given time as lost, and forgets streams which have been idle
+// for the grace period.
+func (s *sequenceTracker) expire(now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for key, stream := range s.streams {
+		for seq, since := range stream.missing {
+			if now.Sub(since) < s.grace {
+				continue
+			}
+			delete(stream.missing, seq)
+			stream.lost[seq] = struct{}{}
+			s.recorder.RecordFailure("messageLoss", 0, errMessageLost)
+		}
+		if len(stream.missing) == 0 && now.Sub(stream.lastSeen) >= s.grace {
+			delete(s.streams, key)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ablyboomer
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSequenceTracker tests detecting lost, duplicate and out of order
+// messages using a sequenceTracker.
+func TestSequenceTracker(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	tracker := newSequenceTracker(recorder, 10*time.Second)
+	now := time.Now()
+
+	// start tracking part way through the stream, skipping 4 and 6 and
+	// receiving 3 twice
+	for _, seq := range []int64{2, 3, 3, 5, 7} {
+		tracker.track("pub1", "chan1", seq, 0, now)
+	}
+
+	// streams are tracked per publisher and channel
+	tracker.track("pub1", "chan2", 1, 0, now)
+	tracker.track("pub2", "chan1", 10, 0, now)
+
+	// receive 4 late but within the grace period
+	tracker.track("pub1", "chan1", 4, 0, now.Add(time.Second))
+
+	// check 6 isn't lost until the grace period has elapsed
+	tracker.expire(now.Add(5 * time.Second))
+	if stats := taskStats(recorder, "messageLoss"); stats.Failures != 0 {
+		t.Fatalf("expected no lost messages within the grace period, got %d", stats.Failures)
+	}
+	tracker.expire(now.Add(10 * time.Second))
+	if stats := taskStats(recorder, "messageLoss"); stats.Failures != 1 {
+		t.Fatalf("expected 1 lost message, got %d", stats.Failures)
+	}
+
+	// receive 6 after it was reported lost
+	tracker.track("pub1", "chan1", 6, 0, now.Add(11*time.Second))
+
+	if stats := taskStats(recorder, "duplicate"); stats.Failures != 1 {
+		t.Fatalf("expected 1 duplicate message, got %d", stats.Failures)
+	}
+	if stats := taskStats(recorder, "outOfOrder"); stats.Failures != 2 {
+		t.Fatalf("expected 2 out of order messages, got %d", stats.Failures)
+	}
+	if stats := taskStats(recorder, "messageLoss"); stats.Failures != 1 {
+		t.Fatalf("expected lost message to only be reported once, got %d", stats.Failures)
+	}
+}
+
+// TestSequenceTrackerBaseline tests messages received with lower sequence
+// numbers than the first message received are reported as out of order
+// rather than as duplicates.
+func TestSequenceTrackerBaseline(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	tracker := newSequenceTracker(recorder, 10*time.Second)
+	now := time.Now()
+
+	// receive 3 first, then 1 and 2 out of order, then 1 again
+	for _, seq := range []int64{3, 1, 2, 4, 1} {
+		tracker.track("pub1", "chan1", seq, 0, now)
+	}
+	tracker.expire(now.Add(10 * time.Second))
+
+	if stats := taskStats(recorder, "outOfOrder"); stats.Failures != 2 {
+		t.Fatalf("expected 2 out of order messages, got %d", stats.Failures)
+	}
+	if stats := taskStats(recorder, "duplicate"); stats.Failures != 1 {
+		t.Fatalf("expected 1 duplicate message, got %d", stats.Failures)
+	}
+	if stats := taskStats(recorder, "messageLoss"); stats.Failures != 0 {
+		t.Fatalf("expected no lost messages, got %d", stats.Failures)
+	}
+}
+
+// TestSequenceTrackerFailed tests messages which failed to publish aren't
+// reported as lost, and that idle streams are forgotten.
+func TestSequenceTrackerFailed(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	tracker := newSequenceTracker(recorder, 10*time.Second)
+	now := time.Now()
+
+	// 2 and 3 failed to publish, and 5 is lost
+	tracker.track("pub1", "chan1", 1, 0, now)
+	tracker.track("pub1", "chan1", 4, 2, now)
+	tracker.track("pub1", "chan1", 6, 0, now)
+	tracker.expire(now.Add(10 * time.Second))
+	if stats := taskStats(recorder, "messageLoss"); stats.Failures != 1 {
+		t.Fatalf("expected 1 lost message, got %d", stats.Failures)
+	}
+
+	// the stream is forgotten once it has been idle for the grace period
+	if n := len(tracker.streams); n != 0 {
+		t.Fatalf("expected idle streams to be forgotten, got %d", n)
+	}
+}
@@ -0,0 +1,171 @@
+package ablyboomer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/ably/ably-boomer/config"
+)
+
+// redacted replaces secrets in the config returned by the /status endpoint.
+const redacted = "[REDACTED]"
+
+// WorkerStatus is the JSON response of the /status endpoint.
+//
+// ConnectionUptime is the percentage of time the worker's Ably connections
+// have spent CONNECTED since they started connecting.
+type WorkerStatus struct {
+	WorkerNumber     int64            `json:"workerNumber"`
+	State            string           `json:"state"`
+	Users            int64            `json:"users"`
+	Tasks            map[string]int64 `json:"tasks"`
+	ConnectionUptime float64          `json:"connectionUptime"`
+	Goroutines       int              `json:"goroutines"`
+	Config           *config.Config   `json:"config"`
+}
+
+// Load test states reported in WorkerStatus.State.
+const (
+	loadTestStateRunning  = "running"
+	loadTestStateStopping = "stopping"
+	loadTestStateStopped  = "stopped"
+)
+
+// startHTTPServer starts an HTTP server listening on conf.HTTP.Addr which
+// serves the handler returned by httpHandler, and returns a function which
+// gracefully shuts the server down.
+func (w *Worker) startHTTPServer() func() {
+	srv := &http.Server{
+		Addr:    w.conf.HTTP.Addr,
+		Handler: w.httpHandler(),
+	}
+	w.log.Info("starting HTTP server", "addr", srv.Addr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.log.Error("error running HTTP server", "err", err)
+		}
+	}()
+	return func() {
+		w.log.Debug("stopping HTTP server")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
+
+// httpHandler returns an http.Handler which serves the following endpoints:
+//
+// /debug/pprof: the standard net/http/pprof endpoints
+//
+// /status:      the worker's status as JSON (see WorkerStatus)
+//
+// /metrics:     Prometheus metrics (populated if conf.Prometheus.Enabled)
+//
+// /healthz:     a liveness check which always responds with 200 OK
+//
+// /readyz:      a readiness check which responds with 200 OK once the worker
+//               is running, and 503 Service Unavailable otherwise
+//
+func (w *Worker) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/status", w.serveStatus)
+	mux.Handle("/metrics", metricsHandler())
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+		if !w.ready.Load() {
+			http.Error(rw, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// serveStatus serves the /status endpoint.
+func (w *Worker) serveStatus(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(w.status()); err != nil {
+		w.log.Error("error encoding worker status", "err", err)
+	}
+}
+
+// status returns the current WorkerStatus.
+//
+// The worker's fields are copied whilst holding the mutex, which isn't held
+// whilst a load test is stopping, so the status can be served throughout.
+func (w *Worker) status() *WorkerStatus {
+	w.mtx.RLock()
+	number, conf, current, stopping := w.number, *w.conf, w.current, w.stopping
+	w.mtx.RUnlock()
+
+	// redact secrets from the copy of the config
+	for _, secret := range []*string{
+		&conf.Ably.APIKey,
+		&conf.Ably.CipherKey,
+		&conf.Perf.SinkURL,
+	} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	conf.Ably.AuthURL = redactURL(conf.Ably.AuthURL)
+	if conf.Custom != nil {
+		conf.Custom = redacted
+	}
+
+	status := &WorkerStatus{
+		WorkerNumber:     number,
+		State:            loadTestStateStopped,
+		Tasks:            map[string]int64{},
+		ConnectionUptime: connectionUptimes.percent(time.Now()),
+		Goroutines:       runtime.NumGoroutine(),
+		Config:           &conf,
+	}
+	if current != nil {
+		status.State = loadTestStateRunning
+		status.Users = current.activeUsers.Load()
+		status.Tasks = current.taskCounts()
+	} else if stopping != nil {
+		status.State = loadTestStateStopping
+		status.Users = stopping.activeUsers.Load()
+		status.Tasks = stopping.taskCounts()
+	}
+	return status
+}
+
+// redactURL returns the given URL with any user info and query param values
+// redacted since they may contain credentials, or redacted entirely if it
+// can't be parsed.
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{redacted}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,292 @@
+package ablyboomer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Supported values for conf.Standalone.SummaryFormat.
+const (
+	SummaryFormatJSON     = "json"
+	SummaryFormatMarkdown = "markdown"
+)
+
+// maxSummaryResponseTime is the highest response time in milliseconds that
+// summary histograms can track, with higher values being recorded as this
+// value.
+const maxSummaryResponseTime = int64(time.Hour / time.Millisecond)
+
+// Summary is a summary of the stats recorded during a load test, which is
+// printed at the end of a standalone run.
+type Summary struct {
+	Start           time.Time      `json:"start"`
+	Duration        time.Duration  `json:"-"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Tasks           []*TaskSummary `json:"tasks"`
+}
+
+// TaskSummary is a summary of the stats recorded with a single name (e.g.
+// "subscribe"), with response times in milliseconds.
+type TaskSummary struct {
+	Name              string           `json:"name"`
+	Count             int64            `json:"count"`
+	Failures          int64            `json:"failures"`
+	FailuresByMessage map[string]int64 `json:"failuresByMessage"`
+	Min               int64            `json:"min"`
+	Mean              float64          `json:"mean"`
+	P50               int64            `json:"p50"`
+	P90               int64            `json:"p90"`
+	P99               int64            `json:"p99"`
+	P999              int64            `json:"p99.9"`
+	Max               int64            `json:"max"`
+	Bytes             int64            `json:"bytes"`
+	MessagesPerSec    float64          `json:"messagesPerSec"`
+	BytesPerSec       float64          `json:"bytesPerSec"`
+}
+
+// Task returns the summary of the task with the given name, or nil if no
+// stats were recorded with that name.
+func (s *Summary) Task(name string) *TaskSummary {
+	for _, task := range s.Tasks {
+		if task.Name == name {
+			return task
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the summary as indented JSON.
+func (s *Summary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteMarkdown writes the summary as Markdown tables.
+func (s *Summary) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## ablyboomer summary\n\n")
+	fmt.Fprintf(&b, "Started at %s, ran for %s.\n\n", s.Start.UTC().Format(time.RFC3339), s.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "Name | Count | Failures | Min | Mean | p50 | p90 | p99 | p99.9 | Max | msgs/sec | bytes/sec\n")
+	fmt.Fprintf(&b, "--- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | ---\n")
+	for _, t := range s.Tasks {
+		fmt.Fprintf(&b, "%s | %d | %d | %d | %.1f | %d | %d | %d | %d | %d | %.2f | %.2f\n",
+			t.Name, t.Count, t.Failures, t.Min, t.Mean, t.P50, t.P90, t.P99, t.P999, t.Max, t.MessagesPerSec, t.BytesPerSec,
+		)
+	}
+	var failures bool
+	for _, t := range s.Tasks {
+		if len(t.FailuresByMessage) == 0 {
+			continue
+		}
+		if !failures {
+			fmt.Fprintf(&b, "\n### Failures\n\n")
+			fmt.Fprintf(&b, "Name | Count | Message\n")
+			fmt.Fprintf(&b, "--- | --- | ---\n")
+			failures = true
+		}
+		messages := make([]string, 0, len(t.FailuresByMessage))
+		for msg := range t.FailuresByMessage {
+			messages = append(messages, msg)
+		}
+		sort.Strings(messages)
+		for _, msg := range messages {
+			fmt.Fprintf(&b, "%s | %d | %s\n", t.Name, t.FailuresByMessage[msg], strings.ReplaceAll(msg, "|", "\\|"))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFile writes the summary to the given file in the given format.
+func (s *Summary) WriteFile(path, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch format {
+	case SummaryFormatJSON:
+		err = s.WriteJSON(f)
+	case SummaryFormatMarkdown:
+		err = s.WriteMarkdown(f)
+	default:
+		err = fmt.Errorf("unknown summary format: %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// taskHistogram aggregates the stats recorded with a single name.
+type taskHistogram struct {
+	hist              *hdrhistogram.Histogram
+	min               int64
+	max               int64
+	failures          int64
+	failuresByMessage map[string]int64
+	bytes             int64
+}
+
+// SummaryRecorder is a Recorder that records response times in HDR
+// histograms so that accurate percentiles can be summarised at the end of a
+// load test (Locust rounds response times into buckets).
+type SummaryRecorder struct {
+	mtx   sync.Mutex
+	start time.Time
+	end   time.Time
+	tasks map[string]*taskHistogram
+}
+
+// NewSummaryRecorder returns a SummaryRecorder which starts recording now.
+func NewSummaryRecorder() *SummaryRecorder {
+	s := &SummaryRecorder{}
+	s.Reset()
+	return s
+}
+
+// Reset clears all the recorded stats and restarts the recording period.
+func (s *SummaryRecorder) Reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.start = time.Now()
+	s.end = time.Time{}
+	s.tasks = make(map[string]*taskHistogram)
+}
+
+// Finish ends the recording period so that the duration and throughput of
+// subsequent summaries don't include time after the load test stopped.
+func (s *SummaryRecorder) Finish() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+}
+
+// get returns the histogram for the given name, initialising it if
+// necessary, and must be called with s.mtx held.
+func (s *SummaryRecorder) get(name string) *taskHistogram {
+	task, ok := s.tasks[name]
+	if !ok {
+		task = &taskHistogram{
+			hist:              hdrhistogram.New(1, maxSummaryResponseTime, 3),
+			failuresByMessage: make(map[string]int64),
+		}
+		s.tasks[name] = task
+	}
+	return task
+}
+
+// RecordSuccess implements the Recorder interface.
+func (s *SummaryRecorder) RecordSuccess(name string, responseTime, responseLength int64) {
+	// clamp the response time to the range the histogram can track (a
+	// negative latency is possible if clocks are skewed)
+	if responseTime < 0 {
+		responseTime = 0
+	} else if responseTime > maxSummaryResponseTime {
+		responseTime = maxSummaryResponseTime
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	task := s.get(name)
+	if task.hist.TotalCount() == 0 || responseTime < task.min {
+		task.min = responseTime
+	}
+	if responseTime > task.max {
+		task.max = responseTime
+	}
+	task.hist.RecordValue(responseTime)
+	task.bytes += responseLength
+}
+
+// RecordFailure implements the Recorder interface.
+func (s *SummaryRecorder) RecordFailure(name string, responseTime int64, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	task := s.get(name)
+	task.failures++
+	task.failuresByMessage[err.Error()]++
+}
+
+// Summary returns a summary of the stats recorded since the recorder was
+// created or last reset, with tasks sorted by name.
+func (s *SummaryRecorder) Summary() *Summary {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.summary()
+}
+
+// Rotate returns a summary of the stats recorded since the recorder was
+// created or last reset, and atomically resets it so that no stats are lost
+// between consecutive summaries.
+func (s *SummaryRecorder) Rotate() *Summary {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	summary := s.summary()
+	s.start = s.start.Add(summary.Duration)
+	s.end = time.Time{}
+	s.tasks = make(map[string]*taskHistogram)
+	return summary
+}
+
+// summary returns a summary of the recorded stats, and must be called with
+// s.mtx held.
+func (s *SummaryRecorder) summary() *Summary {
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	summary := &Summary{
+		Start:    s.start,
+		Duration: end.Sub(s.start),
+	}
+	secs := summary.Duration.Seconds()
+	summary.DurationSeconds = secs
+	for name, task := range s.tasks {
+		// percentiles are the highest value equivalent to the recorded
+		// value in the histogram so may exceed the exact max
+		percentile := func(q float64) int64 {
+			if v := task.hist.ValueAtQuantile(q); v < task.max {
+				return v
+			}
+			return task.max
+		}
+		t := &TaskSummary{
+			Name:              name,
+			Count:             task.hist.TotalCount(),
+			Failures:          task.failures,
+			FailuresByMessage: make(map[string]int64, len(task.failuresByMessage)),
+			Min:               task.min,
+			Mean:              task.hist.Mean(),
+			P50:               percentile(50),
+			P90:               percentile(90),
+			P99:               percentile(99),
+			P999:              percentile(99.9),
+			Max:               task.max,
+			Bytes:             task.bytes,
+		}
+		for msg, count := range task.failuresByMessage {
+			t.FailuresByMessage[msg] = count
+		}
+		if secs > 0 {
+			t.MessagesPerSec = float64(t.Count) / secs
+			t.BytesPerSec = float64(t.Bytes) / secs
+		}
+		summary.Tasks = append(summary.Tasks, t)
+	}
+	sort.Slice(summary.Tasks, func(i, j int) bool {
+		return summary.Tasks[i].Name < summary.Tasks[j].Name
+	})
+	return summary
+}
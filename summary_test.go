@@ -0,0 +1,80 @@
+package ablyboomer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestSummaryRecorder tests summarising stats recorded by a SummaryRecorder.
+func TestSummaryRecorder(t *testing.T) {
+	recorder := NewSummaryRecorder()
+	for i := int64(1); i <= 1000; i++ {
+		recorder.RecordSuccess("subscribe", i, 10)
+	}
+	recorder.RecordFailure("subscribe", 0, errors.New("timeout"))
+	recorder.RecordFailure("publish", 0, errors.New("rate | limited"))
+	recorder.Finish()
+
+	summary := recorder.Summary()
+	if len(summary.Tasks) != 2 || summary.Tasks[0].Name != "publish" || summary.Tasks[1].Name != "subscribe" {
+		t.Fatalf("expected publish and subscribe task summaries, got %v", summary.Tasks)
+	}
+	task := summary.Task("subscribe")
+	if task.Count != 1000 || task.Failures != 1 {
+		t.Fatalf("expected 1000 successes and 1 failure, got %d and %d", task.Count, task.Failures)
+	}
+	if task.Min != 1 || task.Max != 1000 {
+		t.Fatalf("expected min 1 and max 1000, got %d and %d", task.Min, task.Max)
+	}
+	for _, p := range []struct {
+		name     string
+		value    int64
+		expected int64
+	}{
+		{"p50", task.P50, 500},
+		{"p90", task.P90, 900},
+		{"p99", task.P99, 990},
+		{"p99.9", task.P999, 999},
+	} {
+		if p.value != p.expected {
+			t.Fatalf("expected %s to be %d, got %d", p.name, p.expected, p.value)
+		}
+	}
+	if task.Bytes != 10000 {
+		t.Fatalf("expected 10000 bytes, got %d", task.Bytes)
+	}
+	if ratio := task.BytesPerSec / task.MessagesPerSec; task.MessagesPerSec <= 0 || ratio < 9.999 || ratio > 10.001 {
+		t.Fatalf("unexpected throughput: %v msgs/sec, %v bytes/sec", task.MessagesPerSec, task.BytesPerSec)
+	}
+
+	// check the summary is written as JSON
+	var buf bytes.Buffer
+	if err := summary.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Summary
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Task("subscribe").P999 != 999 {
+		t.Fatalf("expected decoded p99.9 to be 999, got %d", decoded.Task("subscribe").P999)
+	}
+
+	// check the summary is written as Markdown
+	buf.Reset()
+	if err := summary.WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"subscribe | 1000 | 1 | 1 | 500.",
+		"subscribe | 1 | timeout",
+		`publish | 1 | rate \| limited`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("expected Markdown summary to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
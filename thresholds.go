@@ -0,0 +1,185 @@
+package ablyboomer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is a pass/fail condition on a metric of a task summary, parsed
+// from a rule like:
+//
+//     subscribe.p99 < 150ms
+//
+// which is the task name, the metric, a comparison operator and a value.
+//
+// Supported metrics are count, failures, failure-ratio, min, mean, p50, p90,
+// p99, p99.9, max, msgs-per-sec and bytes-per-sec. Latency values can be
+// given either as a number of milliseconds or as a duration (e.g. 1.5s),
+// and ratios can be given as a percentage (e.g. 0.1%).
+type Threshold struct {
+	Rule   string
+	Name   string
+	Metric string
+	Op     string
+	Value  float64
+}
+
+// thresholdRuleRe matches a threshold rule.
+var thresholdRuleRe = regexp.MustCompile(`^([^.\s]+)\.(\S+)\s*(<=|>=|<|>)\s*(\S+)$`)
+
+// thresholdMetrics maps supported metric names to functions that return the
+// value of the metric from a task summary.
+var thresholdMetrics = map[string]func(*TaskSummary) float64{
+	"count":    func(t *TaskSummary) float64 { return float64(t.Count) },
+	"failures": func(t *TaskSummary) float64 { return float64(t.Failures) },
+	"failure-ratio": func(t *TaskSummary) float64 {
+		if total := t.Count + t.Failures; total > 0 {
+			return float64(t.Failures) / float64(total)
+		}
+		return 0
+	},
+	"min":           func(t *TaskSummary) float64 { return float64(t.Min) },
+	"mean":          func(t *TaskSummary) float64 { return t.Mean },
+	"p50":           func(t *TaskSummary) float64 { return float64(t.P50) },
+	"p90":           func(t *TaskSummary) float64 { return float64(t.P90) },
+	"p99":           func(t *TaskSummary) float64 { return float64(t.P99) },
+	"p99.9":         func(t *TaskSummary) float64 { return float64(t.P999) },
+	"max":           func(t *TaskSummary) float64 { return float64(t.Max) },
+	"msgs-per-sec":  func(t *TaskSummary) float64 { return t.MessagesPerSec },
+	"bytes-per-sec": func(t *TaskSummary) float64 { return t.BytesPerSec },
+}
+
+// ParseThresholds parses a comma separated list of threshold rules.
+func ParseThresholds(rules string) ([]*Threshold, error) {
+	var thresholds []*Threshold
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		threshold, err := parseThreshold(rule)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+// parseThreshold parses a single threshold rule.
+func parseThreshold(rule string) (*Threshold, error) {
+	match := thresholdRuleRe.FindStringSubmatch(rule)
+	if match == nil {
+		return nil, fmt.Errorf("invalid threshold %q, expected a rule like 'subscribe.p99 < 150ms'", rule)
+	}
+	t := &Threshold{
+		Rule:   rule,
+		Name:   match[1],
+		Metric: match[2],
+		Op:     match[3],
+	}
+	if _, ok := thresholdMetrics[t.Metric]; !ok {
+		return nil, fmt.Errorf("invalid threshold %q, unknown metric %q", rule, t.Metric)
+	}
+	value, err := parseThresholdValue(match[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold %q, %v", rule, err)
+	}
+	t.Value = value
+	return t, nil
+}
+
+// parseThresholdValue parses a threshold value which is either a number, a
+// percentage or a duration (which is converted to milliseconds).
+func parseThresholdValue(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage %q", s)
+		}
+		return v / 100, nil
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return float64(d) / float64(time.Millisecond), nil
+}
+
+// Check checks the threshold against the given summary, returning the actual
+// value of the metric and whether the threshold passed.
+//
+// A task with no recorded stats has a value of zero for every metric.
+func (t *Threshold) Check(summary *Summary) (float64, bool) {
+	task := summary.Task(t.Name)
+	if task == nil {
+		task = &TaskSummary{Name: t.Name}
+	}
+	actual := thresholdMetrics[t.Metric](task)
+	switch t.Op {
+	case "<":
+		return actual, actual < t.Value
+	case "<=":
+		return actual, actual <= t.Value
+	case ">":
+		return actual, actual > t.Value
+	case ">=":
+		return actual, actual >= t.Value
+	default:
+		return actual, false
+	}
+}
+
+// UpperBound returns whether the threshold is an upper bound on its metric
+// (i.e. uses the < or <= operator).
+//
+// Only upper bounds are checked whilst a load test is running, since lower
+// bounds (e.g. "presence.count >= 1" or "publish.msgs-per-sec > 100") are
+// expected to fail until enough stats have been recorded, so are only
+// checked at the end of the run.
+func (t *Threshold) UpperBound() bool {
+	return t.Op == "<" || t.Op == "<="
+}
+
+// ThresholdBreach is a threshold that didn't pass, along with the actual
+// value of the metric.
+type ThresholdBreach struct {
+	Threshold *Threshold
+	Actual    float64
+}
+
+// String returns a description of the breach.
+func (b *ThresholdBreach) String() string {
+	return fmt.Sprintf("%s (actual: %v)", b.Threshold.Rule, b.Actual)
+}
+
+// CheckThresholds checks the given thresholds against the given summary
+// and returns any that were breached.
+func CheckThresholds(thresholds []*Threshold, summary *Summary) []*ThresholdBreach {
+	var breaches []*ThresholdBreach
+	for _, t := range thresholds {
+		if actual, ok := t.Check(summary); !ok {
+			breaches = append(breaches, &ThresholdBreach{Threshold: t, Actual: actual})
+		}
+	}
+	return breaches
+}
+
+// checkRunningThresholds checks the upper bound thresholds against the given
+// summary of a running load test and returns any that were breached (see
+// Threshold.UpperBound).
+func checkRunningThresholds(thresholds []*Threshold, summary *Summary) []*ThresholdBreach {
+	var upper []*Threshold
+	for _, t := range thresholds {
+		if t.UpperBound() {
+			upper = append(upper, t)
+		}
+	}
+	return CheckThresholds(upper, summary)
+}
@@ -0,0 +1,77 @@
+package ablyboomer
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestThresholds tests parsing thresholds and checking them against a
+// summary.
+func TestThresholds(t *testing.T) {
+	thresholds, err := ParseThresholds("subscribe.p99 < 150ms, publish.failure-ratio < 0.1%, reconnect.count <= 2, presence.count >= 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thresholds) != 4 {
+		t.Fatalf("expected 4 thresholds, got %d", len(thresholds))
+	}
+	if v := thresholds[0].Value; v != 150 {
+		t.Fatalf("expected duration to be parsed as 150ms, got %v", v)
+	}
+	if v := thresholds[1].Value; v != 0.001 {
+		t.Fatalf("expected percentage to be parsed as 0.001, got %v", v)
+	}
+
+	recorder := NewSummaryRecorder()
+	for i := int64(1); i <= 100; i++ {
+		recorder.RecordSuccess("subscribe", i, 0)
+	}
+	for i := 0; i < 999; i++ {
+		recorder.RecordSuccess("publish", 0, 0)
+	}
+	recorder.RecordFailure("publish", 0, errors.New("rate limited"))
+	for i := 0; i < 3; i++ {
+		recorder.RecordSuccess("reconnect", 0, 0)
+	}
+
+	// check the reconnect and presence thresholds are breached, and that
+	// the publish failure ratio threshold is breached at exactly 0.1%
+	breaches := CheckThresholds(thresholds, recorder.Summary())
+	var rules []string
+	for _, b := range breaches {
+		rules = append(rules, b.Threshold.Rule)
+	}
+	expected := []string{"publish.failure-ratio < 0.1%", "reconnect.count <= 2", "presence.count >= 1"}
+	if len(rules) != len(expected) {
+		t.Fatalf("expected breaches %v, got %v", expected, rules)
+	}
+	for i := range expected {
+		if rules[i] != expected[i] {
+			t.Fatalf("expected breaches %v, got %v", expected, rules)
+		}
+	}
+	if breaches[1].Actual != 3 {
+		t.Fatalf("expected actual reconnect count 3, got %v", breaches[1].Actual)
+	}
+
+	// check the lower bound presence threshold isn't checked whilst running
+	breaches = checkRunningThresholds(thresholds, recorder.Summary())
+	if len(breaches) != 2 || breaches[0].Threshold.Rule != expected[0] || breaches[1].Threshold.Rule != expected[1] {
+		t.Fatalf("expected running breaches %v, got %v", expected[:2], breaches)
+	}
+	if breaches := checkRunningThresholds(thresholds, NewSummaryRecorder().Summary()); len(breaches) != 0 {
+		t.Fatalf("expected no running breaches before any stats are recorded, got %v", breaches)
+	}
+
+	// check invalid thresholds are rejected
+	for _, rules := range []string{
+		"subscribe p99 < 150",
+		"subscribe.p95 < 150",
+		"subscribe.p99 == 150",
+		"subscribe.p99 < fast",
+	} {
+		if _, err := ParseThresholds(rules); err == nil {
+			t.Fatalf("expected error parsing %q", rules)
+		}
+	}
+}
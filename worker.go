@@ -3,11 +3,14 @@ package ablyboomer
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
 	"sync"
 	"text/template"
 	"time"
 
 	"github.com/ably/ably-boomer/config"
+	"github.com/ably/ably-boomer/perf"
 	"github.com/go-redis/redis/v8"
 	"github.com/inconshreveable/log15"
 	"github.com/myzhan/boomer"
@@ -24,6 +27,15 @@ func WithLog(log log15.Logger) WorkerOption {
 	}
 }
 
+// WithRecorder configures the Recorder used by a Worker and its clients to
+// record stats instead of the default boomer Recorder which reports stats to
+// Locust (stats are also recorded to Prometheus if it's enabled).
+func WithRecorder(recorder Recorder) WorkerOption {
+	return func(w *Worker) {
+		w.recorder = recorder
+	}
+}
+
 // WithSetConfigFunc configures an optional function to set the config before
 // each load test is started.
 func WithSetConfigFunc(f func() *config.Config) WorkerOption {
@@ -45,17 +57,41 @@ func WithSetConfigFunc(f func() *config.Config) WorkerOption {
 // other workers. For example, if a worker is assigned the number 5 in Redis,
 // when it starts a 10 user load test, it will number those users from 41 to 50.
 type Worker struct {
-	conf   *config.Config
-	boomer *boomer.Boomer
+	conf     *config.Config
+	boomer   *boomer.Boomer
+	recorder Recorder
+
+	// summary records stats for the summary printed at the end of a
+	// standalone run, and which thresholds are checked against
+	summary *SummaryRecorder
+
+	// results records stats for each interval exported to conf.Results.File
+	results *SummaryRecorder
+
+	thresholds []*Threshold
+	breachMtx  sync.Mutex
+	breaches   []*ThresholdBreach
 
 	setConfigFunc func() *config.Config
 
 	mtx     sync.RWMutex
 	current *loadTest
 
+	// stopping is the load test which is being stopped, if any
+	stopping *loadTest
+
+	// lifecycleMtx is held whilst a load test is started or stopped so
+	// that a load test isn't started whilst the previous one is still
+	// stopping, without holding mtx whilst stopping
+	lifecycleMtx sync.Mutex
+
 	redis  *redis.Client
 	number int64
 
+	// ready indicates whether the worker is running and ready to accept
+	// load tests, and is reported by the HTTP /readyz endpoint
+	ready atomic.Bool
+
 	log log15.Logger
 }
 
@@ -92,6 +128,52 @@ func NewWorker(conf *config.Config, opts ...WorkerOption) (*Worker, error) {
 		w.boomer = boomer.NewBoomer(conf.Locust.Host, conf.Locust.Port)
 	}
 
+	// record stats to Locust by default, and also to Prometheus if it's
+	// enabled
+	if w.recorder == nil {
+		w.recorder = NewBoomerRecorder(w.boomer)
+	}
+	if conf.Prometheus.Enabled {
+		w.recorder = NewFanoutRecorder(w.recorder, NewPrometheusRecorder())
+	}
+
+	// check the Ably config
+	if err := conf.Ably.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := newAuthenticator(&conf.Ably); err != nil {
+		return nil, err
+	}
+	if err := conf.Subscriber.ValidateRewind(); err != nil {
+		return nil, err
+	}
+
+	// parse the thresholds
+	thresholds, err := ParseThresholds(conf.Thresholds.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid thresholds.rules: %v", err)
+	}
+	w.thresholds = thresholds
+
+	// record stats for an end-of-run summary in standalone mode, or for
+	// checking thresholds
+	if conf.Standalone.Enabled || len(w.thresholds) > 0 {
+		w.summary = NewSummaryRecorder()
+		w.recorder = NewFanoutRecorder(w.recorder, w.summary)
+	}
+
+	// record stats for each interval if exporting results
+	if conf.Results.File != "" {
+		if conf.Results.Format != ResultsFormatCSV && conf.Results.Format != ResultsFormatJSONL {
+			return nil, fmt.Errorf("invalid results.format: %q", conf.Results.Format)
+		}
+		if conf.Results.Interval <= 0 {
+			return nil, fmt.Errorf("invalid results.interval: %v", conf.Results.Interval)
+		}
+		w.results = NewSummaryRecorder()
+		w.recorder = NewFanoutRecorder(w.recorder, w.results)
+	}
+
 	// initialise Redis if enabled
 	if conf.Redis.Enabled {
 		if err := w.connectRedis(); err != nil {
@@ -132,16 +214,60 @@ func (w *Worker) connectRedis() error {
 	}
 }
 
+// Summary returns a summary of the stats recorded during the current (or
+// last) load test when running in standalone mode or with thresholds, and
+// nil otherwise.
+func (w *Worker) Summary() *Summary {
+	if w.summary == nil {
+		return nil
+	}
+	return w.summary.Summary()
+}
+
+// ThresholdBreaches returns the thresholds that were breached at the end of
+// the last load test, or that caused it to be aborted.
+func (w *Worker) ThresholdBreaches() []*ThresholdBreach {
+	w.breachMtx.Lock()
+	defer w.breachMtx.Unlock()
+	return append([]*ThresholdBreach(nil), w.breaches...)
+}
+
 // Run implements the main worker loop which waits for boomer events and
 // starts and stops users.
 //
-// Run blocks until the underlying boomer is stopped.
+// Run blocks until the underlying boomer is stopped, or until
+// conf.Standalone.Duration has elapsed when running in standalone mode, in
+// which case a summary is printed (and optionally written to a file) before
+// returning.
+//
+// If thresholds are configured, they are checked every
+// conf.Thresholds.CheckInterval whilst a load test is running (quitting the
+// boomer if conf.Thresholds.AbortOnBreach is set and a threshold is
+// breached), and again when each load test stops, with the breaches of the
+// last load test available from ThresholdBreaches.
+//
+// If conf.Results.File is set, a row for each task is written to it every
+// conf.Results.Interval until Run returns.
 func (w *Worker) Run(ctx context.Context) {
+	// start the HTTP server if it's enabled (it's also needed to serve
+	// Prometheus metrics)
+	if w.conf.HTTP.Enabled || w.conf.Prometheus.Enabled {
+		stopHTTPServer := w.startHTTPServer()
+		defer stopHTTPServer()
+	}
+
 	// assign a worker number in Redis if it's configured
 	if w.redis != nil {
 		w.assignWorkerNumber(ctx)
 	}
 
+	// start exporting results once the worker number is known as it's
+	// included in each row (and can be used in the file name)
+	stopResultsExport := func() {}
+	if w.results != nil {
+		stopResultsExport = w.startResultsExport()
+	}
+
 	// register handlers for the boomer spawn and stop events
 	w.log.Debug("registering boomer listeners")
 	boomer.Events.Subscribe("boomer:spawn", w.onBoomerSpawn)
@@ -153,6 +279,8 @@ func (w *Worker) Run(ctx context.Context) {
 		Name: "ablyboomer",
 		Fn:   w.onBoomerTask,
 	})
+	w.ready.Store(true)
+	defer w.ready.Store(false)
 
 	// wait for the boomer runner to quit
 	w.log.Debug("waiting for boomer task runner to quit")
@@ -161,26 +289,144 @@ func (w *Worker) Run(ctx context.Context) {
 		w.log.Debug("received boomer:quit event")
 		close(boomerQuit)
 	})
+	var durationC <-chan time.Time
+	if w.conf.Standalone.Enabled && w.conf.Standalone.Duration > 0 {
+		durationC = time.After(w.conf.Standalone.Duration)
+	}
+	abortC := make(chan struct{})
+	if len(w.thresholds) > 0 && w.conf.Thresholds.CheckInterval > 0 {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go w.watchThresholds(watchCtx, abortC)
+	}
 	select {
 	case <-boomerQuit:
 		w.log.Debug("processed boomer:quit event")
 	case <-ctx.Done():
 		w.log.Debug("qutting boomer task runner")
 		w.boomer.Quit()
+	case <-durationC:
+		w.log.Info("standalone duration elapsed, quitting boomer task runner", "duration", w.conf.Standalone.Duration)
+		w.boomer.Quit()
+	case <-abortC:
+		w.log.Info("threshold breached, quitting boomer task runner")
+		w.boomer.Quit()
+	}
+
+	// stop the current load test (if any) so that it is stopped before the
+	// process exits, which is particularly important in standalone mode
+	// where the boomer:stop event is emitted asynchronously on quit and
+	// there may still be profiles to write and upload
+	w.onBoomerStop()
+	stopResultsExport()
+
+	if w.summary != nil && w.conf.Standalone.Enabled {
+		w.reportSummary()
+	}
+}
+
+// watchThresholds checks the upper bound thresholds every
+// conf.Thresholds.CheckInterval whilst a load test is running, logging when they are breached and closing
+// the given abort channel if conf.Thresholds.AbortOnBreach is set.
+func (w *Worker) watchThresholds(ctx context.Context, abortC chan struct{}) {
+	ticker := time.NewTicker(w.conf.Thresholds.CheckInterval)
+	defer ticker.Stop()
+	breached := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		w.mtx.RLock()
+		running := w.current != nil
+		w.mtx.RUnlock()
+		if !running {
+			continue
+		}
+
+		// only log breaches the first time they are seen, and only check
+		// upper bounds until the end of the run
+		breaches := checkRunningThresholds(w.thresholds, w.summary.Summary())
+		current := make(map[string]bool, len(breaches))
+		for _, b := range breaches {
+			current[b.Threshold.Rule] = true
+			if !breached[b.Threshold.Rule] {
+				w.log.Warn("threshold breached", "threshold", b.Threshold.Rule, "actual", b.Actual)
+			}
+		}
+		breached = current
+
+		if len(breaches) > 0 && w.conf.Thresholds.AbortOnBreach {
+			w.log.Error("aborting load test due to threshold breach")
+			w.breachMtx.Lock()
+			w.breaches = breaches
+			w.breachMtx.Unlock()
+			close(abortC)
+			return
+		}
+	}
+}
+
+// checkThresholds checks the thresholds against the summary of the last load
+// test, logging and storing any breaches along with those that caused the
+// load test to be aborted.
+func (w *Worker) checkThresholds() {
+	w.breachMtx.Lock()
+	defer w.breachMtx.Unlock()
+
+	seen := make(map[string]bool)
+	for _, b := range w.breaches {
+		seen[b.Threshold.Rule] = true
+	}
+	for _, b := range CheckThresholds(w.thresholds, w.summary.Summary()) {
+		if !seen[b.Threshold.Rule] {
+			w.breaches = append(w.breaches, b)
+		}
+	}
+
+	if len(w.breaches) == 0 {
+		w.log.Info("all thresholds passed", "count", len(w.thresholds))
+		return
+	}
+	for _, b := range w.breaches {
+		w.log.Error("threshold breached", "threshold", b.Threshold.Rule, "actual", b.Actual)
+	}
+}
+
+// reportSummary prints the summary of a standalone run to stdout, and writes
+// it to conf.Standalone.SummaryFile if set.
+func (w *Worker) reportSummary() {
+	summary := w.Summary()
+	fmt.Println()
+	if err := summary.WriteMarkdown(os.Stdout); err != nil {
+		w.log.Error("error printing summary", "err", err)
+	}
+	if path := w.conf.Standalone.SummaryFile; path != "" {
+		w.log.Info("writing summary", "path", path, "format", w.conf.Standalone.SummaryFormat)
+		if err := summary.WriteFile(path, w.conf.Standalone.SummaryFormat); err != nil {
+			w.log.Error("error writing summary", "path", path, "err", err)
+		}
 	}
 }
 
 // assignWorkerNumber assigns a number to the Worker in Redis by calling the
 // atomic INCR command and using the returned number, just logging an error if
 // it fails (no need to crash the whole load test).
+//
+// The number is set whilst holding the mutex since the HTTP server may be
+// serving the worker's status concurrently.
 func (w *Worker) assignWorkerNumber(ctx context.Context) {
-	var err error
-	w.number, err = w.redis.Incr(ctx, w.conf.Redis.WorkerNumberKey).Result()
+	number, err := w.redis.Incr(ctx, w.conf.Redis.WorkerNumberKey).Result()
 	if err != nil {
 		w.log.Error("error assigning worker number in Redis", "err", err)
-	} else {
-		w.log.Info("assigned worker number in Redis", "number", w.number)
+		return
 	}
+	w.mtx.Lock()
+	w.number = number
+	w.mtx.Unlock()
+	w.log.Info("assigned worker number in Redis", "number", number)
 }
 
 // onBoomerSpawn handles the "boomer:spawn" event by initialising a new load
@@ -205,12 +451,14 @@ func (w *Worker) onBoomerSpawn(userCount int, spawnRate float64) {
 	// so instead we log them, report them to Locust and wait for another
 	// load test to be started
 	reportErr := func(format string, args ...interface{}) {
-		msg := fmt.Sprintf(format, args...)
-		w.log.Error(msg)
-		w.boomer.RecordFailure("ablyboomer", "spawn", 0, msg)
+		err := fmt.Errorf(format, args...)
+		w.log.Error(err.Error())
+		w.recorder.RecordFailure("spawn", 0, err)
 	}
 
-	// hold the mutex whilst the config is validated and the current load test is set
+	// hold the mutexes whilst the config is validated and the current load test is set
+	w.lifecycleMtx.Lock()
+	defer w.lifecycleMtx.Unlock()
 	w.mtx.Lock()
 	defer w.mtx.Unlock()
 
@@ -225,6 +473,7 @@ func (w *Worker) onBoomerSpawn(userCount int, spawnRate float64) {
 	l := &loadTest{
 		w:           w,
 		userCounter: atomic.NewInt64(userNumberStart),
+		started:     time.Now(),
 		stopC:       make(chan struct{}),
 		log:         w.log,
 	}
@@ -236,17 +485,26 @@ func (w *Worker) onBoomerSpawn(userCount int, spawnRate float64) {
 	}
 
 	// ensure at least one task is enabled
-	if !w.conf.Subscriber.Enabled && !w.conf.Publisher.Enabled && !w.conf.Presence.Enabled {
-		reportErr("at least one of subscriber, publisher or presence must be enabled")
+	if !w.conf.Subscriber.Enabled && !w.conf.Publisher.Enabled && !w.conf.Presence.Enabled && !w.conf.PresenceSubscriber.Enabled && !w.conf.History.Enabled {
+		reportErr("at least one of subscriber, publisher, presence, presence-subscriber or history must be enabled")
+		return
+	}
+
+	// parse the clientId and capability templates, which are used to
+	// determine the clientID of each user and to authenticate REST clients
+	auth, err := newAuthenticator(&w.conf.Ably)
+	if err != nil {
+		reportErr("%v", err)
 		return
 	}
+	l.auth = auth
 
 	// parse the channel templates
 	if w.conf.Subscriber.Enabled {
 		channels := w.conf.Subscriber.Channels
 		tmpl, err := template.New("channel").Funcs(channelFuncs).Parse(channels)
 		if err != nil {
-			reportErr("error parsing subscriber channels %q: %w", channels, err)
+			reportErr("error parsing subscriber channels %q: %v", channels, err)
 			return
 		}
 		l.subscriberChannels = tmpl
@@ -255,19 +513,84 @@ func (w *Worker) onBoomerSpawn(userCount int, spawnRate float64) {
 		channels := w.conf.Publisher.Channels
 		tmpl, err := template.New("channel").Funcs(channelFuncs).Parse(channels)
 		if err != nil {
-			reportErr("error parsing publisher channels %q: %w", channels, err)
+			reportErr("error parsing publisher channels %q: %v", channels, err)
 			return
 		}
 		l.publisherChannels = tmpl
+
+		rate, err := newPublishRate(&w.conf.Publisher)
+		if err != nil {
+			reportErr("error configuring publisher rate: %v", err)
+			return
+		}
+		l.publishRate = rate
+
+		if size := w.conf.Publisher.BatchSize; size < 1 {
+			reportErr("invalid publisher batch size: %d", size)
+			return
+		}
+
+		payload, err := newPayloadGenerator(&w.conf.Publisher)
+		if err != nil {
+			reportErr("error configuring publisher payload: %v", err)
+			return
+		}
+		l.payload = payload
+
+		payloadSize, err := newSizeDistribution(&w.conf.Publisher)
+		if err != nil {
+			reportErr("error configuring publisher message size: %v", err)
+			return
+		}
+		l.payloadSize = payloadSize
+
+		messageTemplates, err := newMessageTemplates(&w.conf.Publisher)
+		if err != nil {
+			reportErr("error configuring publisher messages: %v", err)
+			return
+		}
+		l.messageTemplates = messageTemplates
 	}
-	if w.conf.Presence.Enabled {
+	// the presence channels are also used by presence subscribers to
+	// compute the expected presence members
+	if w.conf.Presence.Enabled || w.conf.PresenceSubscriber.Enabled {
 		channels := w.conf.Presence.Channels
 		tmpl, err := template.New("channel").Funcs(channelFuncs).Parse(channels)
 		if err != nil {
-			reportErr("error parsing presence channels %q: %w", channels, err)
+			reportErr("error parsing presence channels %q: %v", channels, err)
 			return
 		}
 		l.presenceChannels = tmpl
+
+		data, err := parsePresenceData(&w.conf.Presence)
+		if err != nil {
+			reportErr("%v", err)
+			return
+		}
+		l.presenceData = data
+	}
+	if w.conf.PresenceSubscriber.Enabled {
+		channels := w.conf.PresenceSubscriber.Channels
+		tmpl, err := template.New("channel").Funcs(channelFuncs).Parse(channels)
+		if err != nil {
+			reportErr("error parsing presence subscriber channels %q: %v", channels, err)
+			return
+		}
+		l.presenceSubscriberChannels = tmpl
+	}
+	if w.conf.History.Enabled {
+		channels := w.conf.History.Channels
+		tmpl, err := template.New("channel").Funcs(channelFuncs).Parse(channels)
+		if err != nil {
+			reportErr("error parsing history channels %q: %v", channels, err)
+			return
+		}
+		l.historyChannels = tmpl
+
+		if err := validateHistoryConfig(&w.conf.History); err != nil {
+			reportErr("error configuring history: %v", err)
+			return
+		}
 	}
 
 	// ensure the configured client exists
@@ -278,21 +601,61 @@ func (w *Worker) onBoomerSpawn(userCount int, spawnRate float64) {
 	}
 	l.newClientFunc = newClientFunc
 
+	// start profiling the load test if configured to do so, naming the
+	// profile after the worker number and start time of the load test so
+	// it can be correlated with other workers participating in the test
+	l.perf = perf.New(w.conf.Perf)
+	testID := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := l.perf.StartForWorker(w.number, testID); err != nil {
+		w.log.Error("error starting perf", "err", err)
+	}
+
+	// start the summary recording period, and forget the threshold
+	// breaches and connection uptime of the previous load test
+	if w.summary != nil {
+		w.summary.Reset()
+	}
+	w.breachMtx.Lock()
+	w.breaches = nil
+	w.breachMtx.Unlock()
+	connectionUptimes.reset(time.Now())
+
 	w.log.Info("setting current load test", "userCount", userCount, "userNumberStart", userNumberStart, "spawnRate", spawnRate)
 	w.current = l
 }
 
-// onBoomerStop handles the "boomer:stop" event by stopping and removing the
-// current load test.
+// onBoomerStop handles the "boomer:stop" event by removing and stopping the
+// current load test, and then ending the summary recording period and
+// checking the thresholds against the load test's stats.
+//
+// The mutex is only held whilst removing the current load test so that the
+// worker's status can be served whilst the load test stops.
 func (w *Worker) onBoomerStop() {
 	w.log.Debug("received boomer:stop event")
+	w.lifecycleMtx.Lock()
+	defer w.lifecycleMtx.Unlock()
+
 	w.mtx.Lock()
-	defer w.mtx.Unlock()
+	current := w.current
+	w.current = nil
+	w.stopping = current
+	w.mtx.Unlock()
+	if current == nil {
+		return
+	}
+
 	w.log.Info("stopping current load test")
-	if w.current != nil {
-		w.current.stop()
-		w.current = nil
+	current.stop()
+	if w.summary != nil {
+		w.summary.Finish()
+	}
+	if len(w.thresholds) > 0 {
+		w.checkThresholds()
 	}
+
+	w.mtx.Lock()
+	w.stopping = nil
+	w.mtx.Unlock()
 }
 
 // onBoomerTask handles a task invocation by the boomer task runner by running
@@ -31,7 +31,8 @@ func TestWorkerStandalone(t *testing.T) {
 
 	events := make(chan testEvent, 12)
 	RegisterNewClientFunc(conf.Client, newTestClientFunc(events))
-	worker, err := NewWorker(conf)
+	recorder := NewSummaryRecorder()
+	worker, err := NewWorker(conf, WithRecorder(recorder))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,6 +73,17 @@ func TestWorkerStandalone(t *testing.T) {
 		return subscribes == 4 && publishes == 4 && presences == 2
 	})
 
+	// wait for the presence stats to be recorded, which happens after the
+	// presence event
+	timeout := time.After(10 * time.Second)
+	for taskStats(recorder, "presence").Count != 2 {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("expected 2 presence successes, got %d", taskStats(recorder, "presence").Count)
+		}
+	}
+
 	// stop the load test, check the users all stop
 	boomer.Events.Publish("boomer:stop")
 	stopped := 0
@@ -84,8 +96,39 @@ func TestWorkerStandalone(t *testing.T) {
 	})
 }
 
+// TestWorkerThresholds tests thresholds are checked when each load test stops,
+// and that breaches don't carry over to the next load test.
+func TestWorkerThresholds(t *testing.T) {
+	conf := config.Default()
+	conf.Subscriber.Enabled = true
+	conf.Thresholds.Rules = "subscribe.count >= 1"
+	worker, err := NewWorker(conf, WithRecorder(NewSummaryRecorder()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check the threshold is breached by a load test with no subscribes
+	worker.onBoomerSpawn(1, 1)
+	worker.onBoomerStop()
+	if breaches := worker.ThresholdBreaches(); len(breaches) != 1 {
+		t.Fatalf("expected 1 breach, got %d", len(breaches))
+	}
+
+	// check the breach is reset when the next load test starts, and that
+	// the next load test passes
+	worker.onBoomerSpawn(1, 1)
+	if breaches := worker.ThresholdBreaches(); len(breaches) != 0 {
+		t.Fatalf("expected breaches to be reset, got %d", len(breaches))
+	}
+	worker.recorder.RecordSuccess("subscribe", 10, 0)
+	worker.onBoomerStop()
+	if breaches := worker.ThresholdBreaches(); len(breaches) != 0 {
+		t.Fatalf("expected no breaches, got %d", len(breaches))
+	}
+}
+
 func newTestClientFunc(events chan testEvent) NewClientFunc {
-	return func(ctx context.Context, conf *config.Config, log log15.Logger) (Client, error) {
+	return func(ctx context.Context, conf *config.Config, recorder Recorder, log log15.Logger) (Client, error) {
 		return &testClient{events}, nil
 	}
 }